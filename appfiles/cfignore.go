@@ -0,0 +1,116 @@
+package appfiles
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultIgnores are left out of every application, as cf push leaves them out
+var DefaultIgnores = []string{".git", ".svn", "_darcs", ".DS_Store", "/manifest.yml"}
+
+// CfIgnore decides which of an application's files are left out of staging, using gitignore-style patterns
+type CfIgnore struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	matcher  *regexp.Regexp
+	negated  bool
+	dirsOnly bool
+}
+
+// ParseCfIgnore reads the .cfignore in appDir on top of the default exclusions. A missing .cfignore just means
+// the defaults are used
+func ParseCfIgnore(appDir string) (*CfIgnore, error) {
+	lines := append([]string{}, DefaultIgnores...)
+	file, err := os.Open(appDir + "/.cfignore")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return NewCfIgnore(lines...), nil
+}
+
+// NewCfIgnore builds a CfIgnore from .cfignore lines. Blank lines and # comments are skipped, ! re-includes what an
+// earlier pattern left out, a trailing / only matches directories, and a pattern containing a / is anchored to the
+// application's root rather than matching at any depth
+func NewCfIgnore(lines ...string) *CfIgnore {
+	cfIgnore := new(CfIgnore)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			pattern.negated = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			pattern.dirsOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		expression := "^" + globExpression(line) + "$"
+		if !anchored {
+			expression = "^(.*/)?" + globExpression(line) + "$"
+		}
+		pattern.matcher = regexp.MustCompile(expression)
+		cfIgnore.patterns = append(cfIgnore.patterns, pattern)
+	}
+	return cfIgnore
+}
+
+// FileShouldBeIgnored checks a slash-separated path relative to the application's root, with the last matching
+// pattern winning. It expects to be asked as a walk reaches each path, so anything inside an ignored directory is
+// never asked about
+func (cfIgnore *CfIgnore) FileShouldBeIgnored(path string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range cfIgnore.patterns {
+		if pattern.dirsOnly && !isDir {
+			continue
+		}
+		if pattern.matcher.MatchString(path) {
+			ignored = !pattern.negated
+		}
+	}
+	return ignored
+}
+
+// globExpression turns a glob into a regular expression, where * and ? stay within a path segment and ** crosses
+// segments
+func globExpression(glob string) string {
+	var expression string
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expression += "(.*/)?"
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expression += ".*"
+			i++
+		case glob[i] == '*':
+			expression += "[^/]*"
+		case glob[i] == '?':
+			expression += "[^/]"
+		default:
+			expression += regexp.QuoteMeta(string(glob[i]))
+		}
+	}
+	return expression
+}
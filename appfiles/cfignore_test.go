@@ -0,0 +1,65 @@
+package appfiles_test
+
+import (
+	"github.com/cloudcredo/cloudrocker/appfiles"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("CfIgnore", func() {
+	It("should ignore CF's default exclusions", func() {
+		cfIgnore := appfiles.NewCfIgnore(appfiles.DefaultIgnores...)
+		Expect(cfIgnore.FileShouldBeIgnored(".git", true)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("vendor/lib/.svn", true)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("_darcs", true)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("public/.DS_Store", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("manifest.yml", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("config/manifest.yml", false)).To(Equal(false))
+		Expect(cfIgnore.FileShouldBeIgnored("app.rb", false)).To(Equal(false))
+	})
+
+	It("should match patterns without a slash at any depth", func() {
+		cfIgnore := appfiles.NewCfIgnore("*.log", "node_modules")
+		Expect(cfIgnore.FileShouldBeIgnored("debug.log", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("logs/debug.log", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("client/node_modules", true)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("debug.log.txt", false)).To(Equal(false))
+	})
+
+	It("should anchor patterns with a slash to the application's root", func() {
+		cfIgnore := appfiles.NewCfIgnore("/target", "config/secrets.yml")
+		Expect(cfIgnore.FileShouldBeIgnored("target", true)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("module/target", true)).To(Equal(false))
+		Expect(cfIgnore.FileShouldBeIgnored("config/secrets.yml", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("app/config/secrets.yml", false)).To(Equal(false))
+	})
+
+	It("should only match directories with a trailing slash", func() {
+		cfIgnore := appfiles.NewCfIgnore("tmp/")
+		Expect(cfIgnore.FileShouldBeIgnored("tmp", true)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("tmp", false)).To(Equal(false))
+		Expect(cfIgnore.FileShouldBeIgnored("app/tmp", true)).To(Equal(true))
+	})
+
+	It("should match across directories with **", func() {
+		cfIgnore := appfiles.NewCfIgnore("docs/**/*.pdf")
+		Expect(cfIgnore.FileShouldBeIgnored("docs/guide.pdf", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("docs/a/b/guide.pdf", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("other/guide.pdf", false)).To(Equal(false))
+	})
+
+	It("should re-include negated patterns, skipping comments and blank lines", func() {
+		cfIgnore := appfiles.NewCfIgnore("# logs", "", "*.log", "!important.log")
+		Expect(cfIgnore.FileShouldBeIgnored("debug.log", false)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("important.log", false)).To(Equal(false))
+		Expect(cfIgnore.FileShouldBeIgnored("# logs", false)).To(Equal(false))
+	})
+
+	It("should use the defaults alone without a .cfignore", func() {
+		cfIgnore, err := appfiles.ParseCfIgnore("/path/that/does/not/exist")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(cfIgnore.FileShouldBeIgnored(".git", true)).To(Equal(true))
+		Expect(cfIgnore.FileShouldBeIgnored("app.rb", false)).To(Equal(false))
+	})
+})
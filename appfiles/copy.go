@@ -0,0 +1,139 @@
+package appfiles
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Report is what was copied from an application directory
+type Report struct {
+	Files int
+	Bytes int64
+}
+
+// Copy copies the application in appDir to dest, leaving out whatever its .cfignore and the default exclusions
+// ignore. Symlinks are copied as symlinks, and files and directories keep their permissions
+func Copy(appDir string, dest string) (Report, error) {
+	return copyTree(appDir, dest, walk)
+}
+
+// CopyDir copies everything in dir to dest as Copy does, but with no ignore rules - for directories that aren't
+// applications, such as buildpacks, whose manifest.yml and dotfiles matter
+func CopyDir(dir string, dest string) (Report, error) {
+	return copyTree(dir, dest, walkAll)
+}
+
+func copyTree(srcDir string, dest string, walker func(string, visitor) error) (Report, error) {
+	report := Report{}
+	srcDir, err := filepath.EvalSymlinks(srcDir)
+	if err != nil {
+		return report, err
+	}
+
+	var dirs []string
+	err = walker(srcDir, func(path string, relativePath string, info os.FileInfo) error {
+		destPath := filepath.Join(dest, relativePath)
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			// directories stay writable until their contents are copied, and get their own permissions after
+			if err := os.MkdirAll(destPath, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, relativePath)
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, destPath); err != nil {
+				return err
+			}
+			report.Files++
+		case mode.IsRegular():
+			if err := copyFile(path, destPath, info); err != nil {
+				return err
+			}
+			report.Files++
+			report.Bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(filepath.Join(srcDir, dirs[i]))
+		if err != nil {
+			return report, err
+		}
+		if err := os.Chmod(filepath.Join(dest, dirs[i]), info.Mode().Perm()); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func copyFile(src string, dest string, info os.FileInfo) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		return err
+	}
+	if err := destination.Close(); err != nil {
+		return err
+	}
+	// the umask may have taken permissions away when the file was created
+	if err := os.Chmod(dest, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+type visitor func(path string, relativePath string, info os.FileInfo) error
+
+// walk visits everything in appDir that its .cfignore and the default exclusions don't ignore, skipping ignored
+// directories entirely
+func walk(appDir string, visit visitor) error {
+	cfIgnore, err := ParseCfIgnore(appDir)
+	if err != nil {
+		return err
+	}
+	return walkUnless(appDir, cfIgnore.FileShouldBeIgnored, visit)
+}
+
+// walkAll visits everything in dir
+func walkAll(dir string, visit visitor) error {
+	return walkUnless(dir, func(string, bool) bool { return false }, visit)
+}
+
+func walkUnless(dir string, ignored func(relativePath string, isDir bool) bool, visit visitor) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if relativePath == "." {
+			return nil
+		}
+		if ignored(filepath.ToSlash(relativePath), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return visit(path, relativePath, info)
+	})
+}
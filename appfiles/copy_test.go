@@ -0,0 +1,89 @@
+package appfiles_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudcredo/cloudrocker/appfiles"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("Copying an application", func() {
+	var (
+		appDir  string
+		destDir string
+	)
+
+	BeforeEach(func() {
+		appDir, _ = ioutil.TempDir(os.TempDir(), "appfiles-test-app")
+		destDir, _ = ioutil.TempDir(os.TempDir(), "appfiles-test-dest")
+		os.MkdirAll(appDir+"/.git/objects", 0755)
+		ioutil.WriteFile(appDir+"/.git/HEAD", []byte("ref: refs/heads/master"), 0644)
+		os.MkdirAll(appDir+"/node_modules/express", 0755)
+		ioutil.WriteFile(appDir+"/node_modules/express/index.js", []byte("module.exports = {}"), 0644)
+		os.MkdirAll(appDir+"/bin", 0755)
+		ioutil.WriteFile(appDir+"/bin/run", []byte("#!/bin/bash"), 0755)
+		ioutil.WriteFile(appDir+"/server.js", []byte("require('express')"), 0644)
+		ioutil.WriteFile(appDir+"/.env", []byte("SECRET=1"), 0600)
+		ioutil.WriteFile(appDir+"/manifest.yml", []byte("---"), 0644)
+		ioutil.WriteFile(appDir+"/.cfignore", []byte("node_modules\n.env\n"), 0644)
+		os.Symlink("server.js", appDir+"/index.js")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(appDir)
+		os.RemoveAll(destDir)
+	})
+
+	It("should leave out what .cfignore and the defaults ignore", func() {
+		_, err := appfiles.Copy(appDir, destDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		contents, _ := ioutil.ReadDir(destDir)
+		names := []string{}
+		for _, file := range contents {
+			names = append(names, file.Name())
+		}
+		Expect(names).To(ConsistOf(".cfignore", "bin", "index.js", "server.js"))
+	})
+
+	It("should keep symlinks and permissions", func() {
+		_, err := appfiles.Copy(appDir, destDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		target, err := os.Readlink(destDir + "/index.js")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(target).To(Equal("server.js"))
+		info, err := os.Stat(destDir + "/bin/run")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		contents, _ := ioutil.ReadFile(destDir + "/server.js")
+		Expect(string(contents)).To(Equal("require('express')"))
+	})
+
+	It("should report how many files and bytes were copied", func() {
+		report, err := appfiles.Copy(appDir, destDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(report.Files).To(Equal(4))
+		Expect(report.Bytes).To(Equal(int64(len("node_modules\n.env\n") + len("#!/bin/bash") + len("require('express')"))))
+	})
+
+	It("should return an error for a missing application directory", func() {
+		_, err := appfiles.Copy(appDir+"/missing", destDir)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should copy everything, ignoring nothing, when copying a directory that isn't an application", func() {
+		_, err := appfiles.CopyDir(appDir, destDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		contents, _ := ioutil.ReadDir(destDir)
+		names := []string{}
+		for _, file := range contents {
+			names = append(names, file.Name())
+		}
+		Expect(names).To(ConsistOf(".cfignore", ".env", ".git", "bin", "index.js", "manifest.yml", "node_modules", "server.js"))
+		info, err := os.Stat(destDir + "/.env")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+})
@@ -0,0 +1,73 @@
+package appfiles
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Digest is a hash of the content of the application at appPath that Copy would copy - the name, permissions and
+// content of every file and the target of every symlink. Timestamps are left out, so touching a file or checking
+// the application out again doesn't change it. An application archive, such as a jar, is hashed as it is
+func Digest(appPath string) (string, error) {
+	return digestTree(appPath, walk)
+}
+
+// DigestDir is a hash of the content of a directory that isn't an application, such as a buildpack, as CopyDir
+// would copy it. A .git directory is left out, as git rewrites its index without the content changing
+func DigestDir(dir string) (string, error) {
+	return digestTree(dir, func(dir string, visit visitor) error {
+		return walkUnless(dir, func(relativePath string, isDir bool) bool { return relativePath == ".git" }, visit)
+	})
+}
+
+func digestTree(appPath string, walker func(string, visitor) error) (string, error) {
+	hash := sha1.New()
+	appPath, err := filepath.EvalSymlinks(appPath)
+	if err != nil {
+		return "", err
+	}
+	appPathInfo, err := os.Stat(appPath)
+	if err != nil {
+		return "", err
+	}
+	if !appPathInfo.IsDir() {
+		if err := hashFile(hash, appPath); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	err = walker(appPath, func(path string, relativePath string, info os.FileInfo) error {
+		fmt.Fprintf(hash, "%s\x00%s\x00", filepath.ToSlash(relativePath), info.Mode())
+		switch mode := info.Mode(); {
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\x00", target)
+		case mode.IsRegular():
+			fmt.Fprintf(hash, "%d\x00", info.Size())
+			return hashFile(hash, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func hashFile(hash io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(hash, file)
+	return err
+}
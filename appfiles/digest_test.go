@@ -0,0 +1,70 @@
+package appfiles_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/cloudcredo/cloudrocker/appfiles"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("Digesting an application", func() {
+	var (
+		appDir string
+		digest string
+	)
+
+	BeforeEach(func() {
+		appDir, _ = ioutil.TempDir(os.TempDir(), "appfiles-test-digest")
+		os.MkdirAll(appDir+"/bin", 0755)
+		ioutil.WriteFile(appDir+"/bin/run", []byte("#!/bin/bash"), 0755)
+		ioutil.WriteFile(appDir+"/server.js", []byte("require('express')"), 0644)
+		ioutil.WriteFile(appDir+"/.cfignore", []byte("logs\n"), 0644)
+		var err error
+		digest, err = appfiles.Digest(appDir)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(appDir)
+	})
+
+	It("should not change when only timestamps or ignored files change", func() {
+		later := time.Now().Add(time.Hour)
+		os.Chtimes(appDir+"/server.js", later, later)
+		os.MkdirAll(appDir+"/logs", 0755)
+		ioutil.WriteFile(appDir+"/logs/app.log", []byte("started"), 0644)
+		Expect(appfiles.Digest(appDir)).To(Equal(digest))
+	})
+
+	It("should change when a file the application ignores changes in a directory that isn't an application", func() {
+		dirDigest, err := appfiles.DigestDir(appDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		ioutil.WriteFile(appDir+"/manifest.yml", []byte("dependencies: []"), 0644)
+		Expect(appfiles.Digest(appDir)).To(Equal(digest))
+		Expect(appfiles.DigestDir(appDir)).ToNot(Equal(dirDigest))
+	})
+
+	It("should change when a file's content changes", func() {
+		ioutil.WriteFile(appDir+"/server.js", []byte("require('koa')"), 0644)
+		Expect(appfiles.Digest(appDir)).ToNot(Equal(digest))
+	})
+
+	It("should change when a file's permissions change", func() {
+		os.Chmod(appDir+"/bin/run", 0644)
+		Expect(appfiles.Digest(appDir)).ToNot(Equal(digest))
+	})
+
+	It("should change when a file is added", func() {
+		ioutil.WriteFile(appDir+"/package.json", []byte("{}"), 0644)
+		Expect(appfiles.Digest(appDir)).ToNot(Equal(digest))
+	})
+
+	It("should return an error for a missing application directory", func() {
+		_, err := appfiles.Digest(appDir + "/missing")
+		Expect(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,232 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// APIVersion is the Open Service Broker API version sent to brokers
+const APIVersion = "2.13"
+
+// Brokers require an organization and space to provision into, so every instance lives in the same local ones
+const (
+	organizationGUID = "cloudrocker-organization"
+	spaceGUID        = "cloudrocker-space"
+)
+
+type Catalog struct {
+	Services []Service `json:"services"`
+}
+
+type Service struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Bindable    bool     `json:"bindable"`
+	Plans       []Plan   `json:"plans"`
+}
+
+type Plan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Binding is what a broker returns for a new service binding
+type Binding struct {
+	Credentials    map[string]interface{} `json:"credentials"`
+	SyslogDrainURL string                 `json:"syslog_drain_url"`
+}
+
+type provisionRequest struct {
+	ServiceID        string                 `json:"service_id"`
+	PlanID           string                 `json:"plan_id"`
+	OrganizationGUID string                 `json:"organization_guid"`
+	SpaceGUID        string                 `json:"space_guid"`
+	Parameters       map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type bindRequest struct {
+	ServiceID string `json:"service_id"`
+	PlanID    string `json:"plan_id"`
+}
+
+type brokerError struct {
+	Error       string `json:"error"`
+	Description string `json:"description"`
+}
+
+// Client talks to a service broker using the Open Service Broker API v2
+type Client struct {
+	URL        string
+	Username   string
+	Password   string
+	httpClient *http.Client
+}
+
+func NewClient(brokerURL string, username string, password string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(brokerURL, "/"),
+		Username:   username,
+		Password:   password,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (client *Client) Catalog() (*Catalog, error) {
+	catalog := new(Catalog)
+	if err := client.do("GET", "/v2/catalog", nil, catalog, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+func (client *Client) Provision(instanceID string, serviceID string, planID string, parameters map[string]interface{}) error {
+	request := provisionRequest{
+		ServiceID:        serviceID,
+		PlanID:           planID,
+		OrganizationGUID: organizationGUID,
+		SpaceGUID:        spaceGUID,
+		Parameters:       parameters,
+	}
+	return client.do("PUT", "/v2/service_instances/"+instanceID, request, nil, http.StatusCreated, http.StatusOK)
+}
+
+func (client *Client) Bind(instanceID string, bindingID string, serviceID string, planID string) (*Binding, error) {
+	binding := new(Binding)
+	request := bindRequest{ServiceID: serviceID, PlanID: planID}
+	path := "/v2/service_instances/" + instanceID + "/service_bindings/" + bindingID
+	if err := client.do("PUT", path, request, binding, http.StatusCreated, http.StatusOK); err != nil {
+		return nil, err
+	}
+	if binding.Credentials == nil {
+		binding.Credentials = map[string]interface{}{}
+	}
+	return binding, nil
+}
+
+// Unbind treats a binding the broker has already forgotten as unbound
+func (client *Client) Unbind(instanceID string, bindingID string, serviceID string, planID string) error {
+	path := "/v2/service_instances/" + instanceID + "/service_bindings/" + bindingID + "?" + planQuery(serviceID, planID)
+	return client.do("DELETE", path, nil, nil, http.StatusOK, http.StatusGone)
+}
+
+// Deprovision treats an instance the broker has already forgotten as deprovisioned
+func (client *Client) Deprovision(instanceID string, serviceID string, planID string) error {
+	path := "/v2/service_instances/" + instanceID + "?" + planQuery(serviceID, planID)
+	return client.do("DELETE", path, nil, nil, http.StatusOK, http.StatusGone)
+}
+
+func planQuery(serviceID string, planID string) string {
+	query := url.Values{}
+	query.Set("service_id", serviceID)
+	query.Set("plan_id", planID)
+	return query.Encode()
+}
+
+func (client *Client) do(method string, path string, requestBody interface{}, responseBody interface{}, expectedStatuses ...int) error {
+	var body io.Reader
+	if requestBody != nil {
+		requestBytes, err := json.Marshal(requestBody)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(requestBytes)
+	}
+
+	request, err := http.NewRequest(method, client.URL+path, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Broker-API-Version", APIVersion)
+	request.Header.Set("Accept", "application/json")
+	if requestBody != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if client.Username != "" {
+		request.SetBasicAuth(client.Username, client.Password)
+	}
+
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Failed to reach service broker at %s: %s", client.URL, err)
+	}
+	defer response.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if !expectedStatus(response.StatusCode, expectedStatuses) {
+		return fmt.Errorf("Service broker %s %s failed with status %d%s", method, strings.SplitN(path, "?", 2)[0], response.StatusCode, errorDescription(responseBytes))
+	}
+	if responseBody != nil {
+		if err := json.Unmarshal(responseBytes, responseBody); err != nil {
+			return fmt.Errorf("Failed to parse service broker response to %s %s: %s", method, path, err)
+		}
+	}
+	return nil
+}
+
+func expectedStatus(status int, expectedStatuses []int) bool {
+	for _, expected := range expectedStatuses {
+		if status == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func errorDescription(responseBytes []byte) string {
+	var brokerErr brokerError
+	if err := json.Unmarshal(responseBytes, &brokerErr); err != nil {
+		return ""
+	}
+	description := strings.TrimSpace(brokerErr.Error + " " + brokerErr.Description)
+	if description == "" {
+		return ""
+	}
+	return ": " + description
+}
+
+func (catalog *Catalog) Service(name string) (Service, bool) {
+	for _, service := range catalog.Services {
+		if service.Name == name {
+			return service, true
+		}
+	}
+	return Service{}, false
+}
+
+// Plan finds one of the service's plans by name, or its first plan when name is empty
+func (service Service) Plan(name string) (Plan, error) {
+	for _, plan := range service.Plans {
+		if name == "" || plan.Name == name {
+			return plan, nil
+		}
+	}
+	if name == "" {
+		return Plan{}, fmt.Errorf("Service %s has no plans", service.Name)
+	}
+	return Plan{}, fmt.Errorf("Service %s has no plan %s", service.Name, name)
+}
+
+// NewGUID makes a random (version 4) UUID for instance and binding IDs
+func NewGUID() (string, error) {
+	guid := make([]byte, 16)
+	if _, err := rand.Read(guid); err != nil {
+		return "", fmt.Errorf("Failed to generate a GUID: %s", err)
+	}
+	guid[6] = (guid[6] & 0x0f) | 0x40
+	guid[8] = (guid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", guid[0:4], guid[4:6], guid[6:8], guid[8:10], guid[10:]), nil
+}
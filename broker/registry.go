@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Broker is a service broker registered with Cloud Rocker, kept under CLOUDROCKER_HOME
+type Broker struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (broker *Broker) Client() *Client {
+	return NewClient(broker.URL, broker.Username, broker.Password)
+}
+
+// Each broker is kept in its own JSON file in the brokers directory, readable only by the user as it holds a password
+func Save(brokersDir string, broker *Broker) error {
+	if err := os.MkdirAll(brokersDir, 0755); err != nil {
+		return err
+	}
+	brokerBytes, err := json.MarshalIndent(broker, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(brokerPath(brokersDir, broker.Name), brokerBytes, 0600)
+}
+
+func Load(brokersDir string, name string) (*Broker, error) {
+	brokerBytes, err := ioutil.ReadFile(brokerPath(brokersDir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Service broker %s does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	broker := new(Broker)
+	if err := json.Unmarshal(brokerBytes, broker); err != nil {
+		return nil, fmt.Errorf("Failed to parse service broker %s: %s", name, err)
+	}
+	return broker, nil
+}
+
+func Exists(brokersDir string, name string) bool {
+	_, err := os.Stat(brokerPath(brokersDir, name))
+	return err == nil
+}
+
+// List returns the brokers sorted by name
+func List(brokersDir string) ([]*Broker, error) {
+	brokers := []*Broker{}
+	paths, err := filepath.Glob(brokersDir + "/*.json")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		broker, err := Load(brokersDir, strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		brokers = append(brokers, broker)
+	}
+	return brokers, nil
+}
+
+func Delete(brokersDir string, name string) error {
+	return os.Remove(brokerPath(brokersDir, name))
+}
+
+func brokerPath(brokersDir string, name string) string {
+	return brokersDir + "/" + name + ".json"
+}
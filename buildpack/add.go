@@ -0,0 +1,264 @@
+package buildpack
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle/buildpackrunner"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/pivotal-golang/archiver/extractor"
+	"github.com/cloudcredo/cloudrocker/appfiles"
+)
+
+// AddOptions choose how a buildpack is installed. Name defaults to the name of the repository, zip file or
+// directory the buildpack comes from. Link symlinks a local directory rather than copying it, so changes to the
+// buildpack are picked up by the next staging
+type AddOptions struct {
+	Name string
+	Link bool
+}
+
+// Add installs a buildpack from a git URL, optionally pinned to a branch, tag or commit with a #ref fragment, from a
+// zip file, local or downloaded, or from a local directory
+func Add(writer io.Writer, source string, buildpackDir string, options AddOptions) error {
+	name := options.Name
+	if name == "" {
+		name = defaultName(source)
+	}
+	if name == "" || strings.HasPrefix(name, ".") || strings.Contains(name, "/") {
+		return fmt.Errorf("Invalid buildpack name %s - choose another with --name", name)
+	}
+	if _, err := os.Lstat(buildpackDir + "/" + name); err == nil {
+		return fmt.Errorf("Buildpack %s is already installed - delete it first, or choose another name with --name", name)
+	}
+	if err := os.MkdirAll(buildpackDir, 0755); err != nil {
+		return fmt.Errorf("Buildpack directory creation error: %s", err)
+	}
+
+	sourceInfo, err := os.Stat(source)
+	isLocal := err == nil
+	if isLocal {
+		//local sources are recorded by their absolute path, so the buildpack can be updated from anywhere
+		if source, err = filepath.Abs(source); err != nil {
+			return err
+		}
+	}
+	if options.Link {
+		if !isLocal || !sourceInfo.IsDir() {
+			return fmt.Errorf("Only a local directory can be linked as a buildpack")
+		}
+		if err := os.Symlink(source, buildpackDir+"/"+name); err != nil {
+			return err
+		}
+		if err := recordInstall(name, source, buildpackDir); err != nil {
+			return err
+		}
+		fmt.Fprintf(writer, "Linked buildpack %s to %s.\n", name, source)
+		return nil
+	}
+
+	tmpDir, fetchedDir, err := fetch(writer, source, buildpackDir, name)
+	defer os.RemoveAll(tmpDir)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(fetchedDir, buildpackDir+"/"+name); err != nil {
+		return err
+	}
+	if err := recordInstall(name, source, buildpackDir); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Added buildpack %s.\n", name)
+	return nil
+}
+
+// fetch gets a buildpack from its source into a hidden directory, so a failed fetch leaves nothing behind. It returns
+// that directory, for the caller to remove, and where in it the buildpack is
+func fetch(writer io.Writer, source string, buildpackDir string, name string) (string, string, error) {
+	tmpDir, err := ioutil.TempDir(buildpackDir, ".adding-"+name)
+	if err != nil {
+		return "", "", err
+	}
+
+	sourceInfo, statErr := os.Stat(source)
+	switch {
+	case statErr == nil && sourceInfo.IsDir():
+		fmt.Fprintln(writer, "Copying buildpack...")
+		_, err = appfiles.CopyDir(source, tmpDir)
+	case statErr == nil:
+		fmt.Fprintln(writer, "Extracting buildpack...")
+		err = extractor.NewZip().Extract(source, tmpDir)
+	case IsURL(source) && buildpackrunner.IsZipFile(urlPath(source)):
+		fmt.Fprintln(writer, "Downloading buildpack...")
+		err = downloadZip(source, tmpDir)
+	case IsURL(source):
+		fmt.Fprintln(writer, "Downloading buildpack...")
+		err = gitClone(writer, source, tmpDir)
+	default:
+		return tmpDir, "", fmt.Errorf("Buildpack %s is neither a URL, a zip file nor a directory", source)
+	}
+	if err != nil {
+		return tmpDir, "", fmt.Errorf("Error fetching buildpack: %s", err)
+	}
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return tmpDir, "", err
+	}
+	return tmpDir, unnest(tmpDir), nil
+}
+
+// Update fetches a buildpack from its source again - the latest commit of its branch, or whatever its zip file or
+// directory holds now - and records what it fetched. A failed update leaves the buildpack as it was
+func Update(writer io.Writer, buildpack string, buildpackDir string) error {
+	metadata, err := ReadMetadata(buildpack, buildpackDir)
+	if err != nil {
+		return err
+	}
+	if metadata.Linked {
+		fmt.Fprintf(writer, "Buildpack %s is linked, so it is always up to date.\n", buildpack)
+		return nil
+	}
+	if metadata.Source == "" {
+		return fmt.Errorf("The source of buildpack %s wasn't recorded - delete it and add it again to be able to update it", buildpack)
+	}
+
+	//the commit, or a digest of a buildpack that has none, tells whether the buildpack has changed
+	oldVersion, err := Version(buildpack, buildpackDir)
+	if err != nil {
+		return err
+	}
+	tmpDir, fetchedDir, err := fetch(writer, metadata.Source, buildpackDir, buildpack)
+	defer os.RemoveAll(tmpDir)
+	if err != nil {
+		return err
+	}
+	oldDir := tmpDir + "-old"
+	if err := os.Rename(buildpackDir+"/"+buildpack, oldDir); err != nil {
+		return err
+	}
+	if err := os.Rename(fetchedDir, buildpackDir+"/"+buildpack); err != nil {
+		os.Rename(oldDir, buildpackDir+"/"+buildpack)
+		return err
+	}
+	os.RemoveAll(oldDir)
+	if err := recordInstall(buildpack, metadata.Source, buildpackDir); err != nil {
+		return err
+	}
+
+	updated, err := ReadMetadata(buildpack, buildpackDir)
+	if err != nil {
+		return err
+	}
+	newVersion, err := Version(buildpack, buildpackDir)
+	if err != nil {
+		return err
+	}
+	if newVersion == oldVersion {
+		fmt.Fprintf(writer, "Buildpack %s is up to date at %s.\n", buildpack, updated.Describe())
+		return nil
+	}
+	fmt.Fprintf(writer, "Updated buildpack %s from %s to %s.\n", buildpack, metadata.Describe(), updated.Describe())
+	return nil
+}
+
+// UpdateAll updates every installed buildpack, carrying on past any that fail
+func UpdateAll(writer io.Writer, buildpackDir string) error {
+	buildpacks, err := DetectOrder(buildpackDir)
+	if err != nil {
+		return err
+	}
+	failed := []string{}
+	for _, buildpack := range buildpacks {
+		if err := Update(writer, buildpack, buildpackDir); err != nil {
+			fmt.Fprintf(writer, "%s\n", err)
+			failed = append(failed, buildpack)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to update buildpacks %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func defaultName(source string) string {
+	name := source
+	if IsURL(source) {
+		name = urlPath(source)
+	}
+	name = path.Base(strings.TrimSuffix(filepath.ToSlash(name), "/"))
+	for _, suffix := range []string{".git", ".zip"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+func urlPath(source string) string {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return ""
+	}
+	return sourceURL.Path
+}
+
+func downloadZip(source string, dest string) error {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return err
+	}
+	_, err = buildpackrunner.NewZipDownloader(false).DownloadAndExtract(sourceURL, dest)
+	return err
+}
+
+// A branch or tag can be cloned shallowly; a commit needs the whole history before it can be checked out
+func gitClone(writer io.Writer, source string, dest string) error {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return err
+	}
+	ref := sourceURL.Fragment
+	sourceURL.Fragment = ""
+
+	args := []string{"clone", "--depth=1", "--recursive"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	if err := runGit(writer, "", append(args, sourceURL.String(), dest)...); err == nil || ref == "" {
+		return err
+	}
+	os.RemoveAll(dest)
+	if err := runGit(writer, "", "clone", "--recursive", sourceURL.String(), dest); err != nil {
+		return err
+	}
+	if err := runGit(writer, dest, "checkout", ref); err != nil {
+		return fmt.Errorf("%s has no branch, tag or commit %s", sourceURL.String(), ref)
+	}
+	return runGit(writer, dest, "submodule", "update", "--init", "--recursive")
+}
+
+func runGit(writer io.Writer, dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// Zip files, such as GitHub's archives, often hold the buildpack in a single top level directory
+func unnest(installDir string) string {
+	if _, err := os.Stat(installDir + "/bin"); err == nil {
+		return installDir
+	}
+	contents, err := ioutil.ReadDir(installDir)
+	if err != nil || len(contents) != 1 || !contents[0].IsDir() {
+		return installDir
+	}
+	return installDir + "/" + contents[0].Name()
+}
@@ -0,0 +1,159 @@
+package buildpack_test
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/cloudcredo/cloudrocker/buildpack"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Adding buildpacks from local sources", func() {
+	var (
+		buffer       *gbytes.Buffer
+		tmpDir       string
+		buildpackDir string
+		sourceDir    string
+	)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = sourceDir
+		output, err := cmd.CombinedOutput()
+		Expect(err).ShouldNot(HaveOccurred(), string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		tmpDir, _ = ioutil.TempDir(os.TempDir(), "rocker-buildpack-add-test")
+		buildpackDir = tmpDir + "/buildpacks"
+		sourceDir = tmpDir + "/my-buildpack"
+		os.MkdirAll(sourceDir+"/bin", 0755)
+		ioutil.WriteFile(sourceDir+"/bin/detect", []byte("#!/bin/bash\necho v1"), 0755)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Context("from a local directory", func() {
+		It("should copy the directory, named after it", func() {
+			err := buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(buffer).Should(gbytes.Say("Added buildpack my-buildpack."))
+			info, err := os.Lstat(buildpackDir + "/my-buildpack/bin/detect")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+			Expect(buildpack.Linked("my-buildpack", buildpackDir)).To(Equal(false))
+			Expect(buildpack.DetectOrder(buildpackDir)).To(Equal([]string{"my-buildpack"}))
+		})
+
+		It("should copy the manifest.yml and dotfiles an application would leave out", func() {
+			ioutil.WriteFile(sourceDir+"/manifest.yml", []byte("dependencies: []"), 0644)
+			ioutil.WriteFile(sourceDir+"/.cfignore", []byte("bin\n"), 0644)
+			os.MkdirAll(sourceDir+"/dependencies", 0755)
+			ioutil.WriteFile(sourceDir+"/dependencies/.keep", []byte(""), 0644)
+			err := buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			for _, file := range []string{"manifest.yml", ".cfignore", "dependencies/.keep", "bin/detect"} {
+				_, err := os.Stat(buildpackDir + "/my-buildpack/" + file)
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+		})
+
+		It("should link the directory when asked to", func() {
+			err := buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{Name: "dev", Link: true})
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(buffer).Should(gbytes.Say("Linked buildpack dev to .*/my-buildpack"))
+			Expect(buildpack.Linked("dev", buildpackDir)).To(Equal(true))
+			Expect(buildpack.Installed("dev", buildpackDir)).To(Equal(true))
+			Expect(buildpack.LinkedDirs(buildpackDir)).To(Equal([]string{sourceDir}))
+			Expect(buildpack.DetectOrder(buildpackDir)).To(Equal([]string{"dev"}))
+		})
+
+		It("should refuse to link anything but a local directory", func() {
+			err := buildpack.Add(buffer, "https://github.com/cloudfoundry/ruby-buildpack", buildpackDir, buildpack.AddOptions{Link: true})
+			Expect(err).Should(MatchError("Only a local directory can be linked as a buildpack"))
+		})
+
+		It("should refuse a name that is already installed", func() {
+			buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{})
+			err := buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{})
+			Expect(err).Should(MatchError("Buildpack my-buildpack is already installed - delete it first, or choose another name with --name"))
+		})
+	})
+
+	Context("from a zip file", func() {
+		It("should extract the buildpack from the single directory GitHub archives hold it in", func() {
+			zipFile, _ := os.Create(tmpDir + "/my-buildpack-1.0.zip")
+			zipWriter := zip.NewWriter(zipFile)
+			header := &zip.FileHeader{Name: "my-buildpack-1.0/bin/detect", Method: zip.Deflate}
+			header.SetMode(0755)
+			entry, _ := zipWriter.CreateHeader(header)
+			entry.Write([]byte("#!/bin/bash"))
+			zipWriter.Close()
+			zipFile.Close()
+
+			err := buildpack.Add(buffer, tmpDir+"/my-buildpack-1.0.zip", buildpackDir, buildpack.AddOptions{Name: "offline"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(buffer).Should(gbytes.Say("Extracting buildpack..."))
+			Eventually(buffer).Should(gbytes.Say("Added buildpack offline."))
+			contents, _ := ioutil.ReadFile(buildpackDir + "/offline/bin/detect")
+			Expect(string(contents)).To(Equal("#!/bin/bash"))
+		})
+
+		It("should leave nothing behind if the file isn't a zip file", func() {
+			ioutil.WriteFile(tmpDir+"/broken.zip", []byte("not a zip"), 0644)
+			err := buildpack.Add(buffer, tmpDir+"/broken.zip", buildpackDir, buildpack.AddOptions{})
+			Expect(err).Should(HaveOccurred())
+			contents, _ := ioutil.ReadDir(buildpackDir)
+			Expect(contents).To(BeEmpty())
+		})
+	})
+
+	Context("from a git repository", func() {
+		var firstCommit string
+
+		BeforeEach(func() {
+			git("init", "-q")
+			git("add", ".")
+			git("commit", "-q", "-m", "v1")
+			git("tag", "v1")
+			firstCommit = git("rev-parse", "HEAD")
+			ioutil.WriteFile(sourceDir+"/bin/detect", []byte("#!/bin/bash\necho v2"), 0755)
+			git("commit", "-q", "-a", "-m", "v2")
+		})
+
+		It("should clone the latest commit", func() {
+			err := buildpack.Add(buffer, "file://"+sourceDir, buildpackDir, buildpack.AddOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			contents, _ := ioutil.ReadFile(buildpackDir + "/my-buildpack/bin/detect")
+			Expect(string(contents)).To(ContainSubstring("v2"))
+		})
+
+		It("should pin the buildpack to the tag in the URL's fragment", func() {
+			err := buildpack.Add(buffer, "file://"+sourceDir+"#v1", buildpackDir, buildpack.AddOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(buildpack.Version("my-buildpack", buildpackDir)).To(Equal(firstCommit))
+		})
+
+		It("should pin the buildpack to the commit in the URL's fragment", func() {
+			err := buildpack.Add(buffer, "file://"+sourceDir+"#"+firstCommit, buildpackDir, buildpack.AddOptions{Name: "pinned"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(buildpack.Version("pinned", buildpackDir)).To(Equal(firstCommit))
+		})
+
+		It("should say when the ref doesn't exist", func() {
+			err := buildpack.Add(buffer, "file://"+sourceDir+"#v3", buildpackDir, buildpack.AddOptions{})
+			Expect(err).Should(MatchError("Error fetching buildpack: file://" + sourceDir + " has no branch, tag or commit v3"))
+			Expect(buildpack.Installed("my-buildpack", buildpackDir)).To(Equal(false))
+		})
+	})
+})
@@ -3,40 +3,32 @@ package buildpack
 import (
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/cloudcredo/cloudrocker/appfiles"
 	"github.com/cloudcredo/cloudrocker/utils"
 )
 
-func Add(writer io.Writer, url string, buildpackDir string) {
-	err := os.MkdirAll(buildpackDir, 0755)
-	if err != nil {
-		log.Fatalf("Buildpack directory creation error: %s", err)
-	}
-	fmt.Fprintln(writer, "Downloading buildpack...")
-	cmd := exec.Command("git", "clone", "--depth=1", "--recursive", url)
-	cmd.Stdout = writer
-	cmd.Stderr = writer
-	cmd.Dir = buildpackDir
-	err = cmd.Run()
-	if err != nil {
-		log.Fatalf("Error downloading buildpack: %s", err)
-	}
-	fmt.Fprintln(writer, "Downloaded buildpack.")
-}
-
 func Delete(writer io.Writer, buildpack string, buildpackDir string) error {
 	if err := os.RemoveAll(buildpackDir + "/" + buildpack); err != nil {
 		return err
 	}
+	if err := forgetInstall(buildpack, buildpackDir); err != nil {
+		return err
+	}
 	fmt.Fprintln(writer, "Deleted buildpack.")
 	return nil
 }
 
+// List shows the buildpacks in the order they are detected in
 func List(writer io.Writer, buildpackDir string) (err error) {
-	if buildpacks, err := utils.SubDirs(buildpackDir); err == nil {
+	if buildpacks, err := DetectOrder(buildpackDir); err == nil {
 		for _, buildpack := range buildpacks {
 			fmt.Fprintln(writer, buildpack)
 		}
@@ -48,13 +40,155 @@ func List(writer io.Writer, buildpackDir string) (err error) {
 }
 
 func AtLeastOneBuildpackIn(buildpackDir string) error {
-	var subDirs []string
-	var err error
-	if subDirs, err = utils.SubDirs(buildpackDir); err != nil {
+	buildpacks, err := installed(buildpackDir)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	if len(subDirs) == 0 {
+	if len(buildpacks) == 0 {
 		return fmt.Errorf("No buildpacks detected - please add one")
 	}
 	return nil
 }
+
+// The user's detect order is kept with the buildpacks, so it is also available inside the staging container
+const detectOrderFile = ".detect-order"
+
+// DetectOrder lists the installed buildpacks in the order their detect scripts run: the order chosen with
+// SetDetectOrder first, then any other buildpacks by name
+func DetectOrder(buildpackDir string) ([]string, error) {
+	installed, err := installed(buildpackDir)
+	if err != nil {
+		return nil, err
+	}
+	chosen, err := readDetectOrder(buildpackDir)
+	if err != nil {
+		return nil, err
+	}
+	order := []string{}
+	for _, buildpack := range chosen {
+		if contains(installed, buildpack) && !contains(order, buildpack) {
+			order = append(order, buildpack)
+		}
+	}
+	sort.Strings(installed)
+	for _, buildpack := range installed {
+		if !contains(order, buildpack) {
+			order = append(order, buildpack)
+		}
+	}
+	return order, nil
+}
+
+// SetDetectOrder puts the given buildpacks first when detecting, in the order given
+func SetDetectOrder(writer io.Writer, buildpacks []string, buildpackDir string) error {
+	for _, buildpack := range buildpacks {
+		if !Installed(buildpack, buildpackDir) {
+			return fmt.Errorf("Buildpack %s is not installed", buildpack)
+		}
+	}
+	if err := ioutil.WriteFile(buildpackDir+"/"+detectOrderFile, []byte(strings.Join(buildpacks, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintln(writer, "Set the buildpack detect order.")
+	return nil
+}
+
+func readDetectOrder(buildpackDir string) ([]string, error) {
+	orderBytes, err := ioutil.ReadFile(buildpackDir + "/" + detectOrderFile)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(orderBytes)), nil
+}
+
+func Installed(buildpack string, buildpackDir string) bool {
+	if buildpack == "" || strings.Contains(buildpack, "/") {
+		return false
+	}
+	info, err := os.Stat(buildpackDir + "/" + buildpack)
+	return err == nil && info.IsDir()
+}
+
+// Version identifies what an installed buildpack contains: the commit it was cloned at, or a digest of its files if
+// it isn't a git checkout. A linked buildpack is always digested, as it may have changes that aren't committed
+func Version(buildpack string, buildpackDir string) (string, error) {
+	if !Linked(buildpack, buildpackDir) {
+		if commit := gitCommit(buildpackDir + "/" + buildpack); commit != "" {
+			return commit, nil
+		}
+	}
+	return appfiles.DigestDir(buildpackDir + "/" + buildpack)
+}
+
+// gitCommit is the commit of the buildpack's own checkout. git would otherwise report the commit of any repository
+// the buildpack directory sits inside, such as a $HOME kept in git, which doesn't change with the buildpack
+func gitCommit(dir string) string {
+	if _, err := os.Stat(dir + "/.git"); err != nil {
+		return ""
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Linked tells buildpacks added with --link, which are symlinks to a directory elsewhere, apart from copies
+func Linked(buildpack string, buildpackDir string) bool {
+	info, err := os.Lstat(buildpackDir + "/" + buildpack)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// LinkedDirs are the directories that linked buildpacks point to
+func LinkedDirs(buildpackDir string) ([]string, error) {
+	buildpacks, err := installed(buildpackDir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	for _, buildpack := range buildpacks {
+		if Linked(buildpack, buildpackDir) {
+			dir, err := filepath.EvalSymlinks(buildpackDir + "/" + buildpack)
+			if err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// Hidden directories are buildpacks that are still being added
+func installed(buildpackDir string) ([]string, error) {
+	dirs, err := utils.SubDirs(buildpackDir)
+	if err != nil {
+		return nil, err
+	}
+	buildpacks := []string{}
+	for _, dir := range dirs {
+		if !strings.HasPrefix(dir, ".") {
+			buildpacks = append(buildpacks, dir)
+		}
+	}
+	return buildpacks, nil
+}
+
+// IsURL tells buildpacks the lifecycle downloads itself apart from installed ones
+func IsURL(buildpack string) bool {
+	buildpackURL, err := url.Parse(buildpack)
+	return err == nil && buildpackURL.IsAbs()
+}
+
+func contains(list []string, item string) bool {
+	for _, listItem := range list {
+		if listItem == item {
+			return true
+		}
+	}
+	return false
+}
@@ -3,7 +3,10 @@ package buildpack_test
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"strings"
 
+	"github.com/cloudcredo/cloudrocker/appfiles"
 	"github.com/cloudcredo/cloudrocker/buildpack"
 
 	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
@@ -26,9 +29,10 @@ var _ = Describe("Buildpack", func() {
 
 	Describe("Adding a Buildpack", func() {
 		It("should download the buildpack from the specified URL", func() {
-			buildpack.Add(buffer, "https://github.com/hatofmonkeys/not-a-buildpack", buildpackDir)
+			err := buildpack.Add(buffer, "https://github.com/hatofmonkeys/not-a-buildpack", buildpackDir, buildpack.AddOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
 			Eventually(buffer).Should(gbytes.Say(`Downloading buildpack...`))
-			Eventually(buffer).Should(gbytes.Say(`Downloaded buildpack.`))
+			Eventually(buffer).Should(gbytes.Say(`Added buildpack not-a-buildpack.`))
 			contents, err := ioutil.ReadDir(buildpackDir + "/not-a-buildpack")
 			Expect(contents, err).Should(HaveLen(3))
 		})
@@ -93,5 +97,111 @@ var _ = Describe("Buildpack", func() {
 				Expect(err).Should(HaveOccurred())
 			})
 		})
+
+		Context("without a buildpack directory", func() {
+			It("should say there are no buildpacks", func() {
+				err := buildpack.AtLeastOneBuildpackIn(buildpackDir + "/missing")
+				Expect(err).Should(MatchError("No buildpacks detected - please add one"))
+			})
+		})
+	})
+
+	Describe("Ordering buildpack detection", func() {
+		BeforeEach(func() {
+			for _, name := range []string{"ruby-buildpack", "java-buildpack", "go-buildpack"} {
+				os.Mkdir(buildpackDir+"/"+name, 0755)
+			}
+		})
+
+		It("should detect the buildpacks by name by default", func() {
+			order, err := buildpack.DetectOrder(buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(order).To(Equal([]string{"go-buildpack", "java-buildpack", "ruby-buildpack"}))
+		})
+
+		It("should detect the chosen buildpacks first, then the rest", func() {
+			err := buildpack.SetDetectOrder(buffer, []string{"ruby-buildpack", "go-buildpack"}, buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(buffer).Should(gbytes.Say(`Set the buildpack detect order.`))
+			order, err := buildpack.DetectOrder(buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(order).To(Equal([]string{"ruby-buildpack", "go-buildpack", "java-buildpack"}))
+
+			buildpack.List(buffer, buildpackDir)
+			Eventually(buffer).Should(gbytes.Say(`ruby-buildpack\ngo-buildpack\njava-buildpack`))
+		})
+
+		It("should forget buildpacks that have since been deleted", func() {
+			buildpack.SetDetectOrder(buffer, []string{"ruby-buildpack", "go-buildpack"}, buildpackDir)
+			buildpack.Delete(buffer, "ruby-buildpack", buildpackDir)
+			order, err := buildpack.DetectOrder(buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(order).To(Equal([]string{"go-buildpack", "java-buildpack"}))
+		})
+
+		It("should refuse to order a buildpack that isn't installed", func() {
+			err := buildpack.SetDetectOrder(buffer, []string{"php-buildpack"}, buildpackDir)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Versioning a buildpack", func() {
+		It("should use the commit a cloned buildpack is at", func() {
+			os.MkdirAll(buildpackDir+"/testbuildpack/bin", 0755)
+			ioutil.WriteFile(buildpackDir+"/testbuildpack/bin/detect", []byte("#!/bin/bash"), 0755)
+			for _, args := range [][]string{
+				{"init", "-q"},
+				{"add", "."},
+				{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "test"},
+			} {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = buildpackDir + "/testbuildpack"
+				Expect(cmd.Run()).ShouldNot(HaveOccurred())
+			}
+			cmd := exec.Command("git", "rev-parse", "HEAD")
+			cmd.Dir = buildpackDir + "/testbuildpack"
+			commit, _ := cmd.Output()
+			Expect(buildpack.Version("testbuildpack", buildpackDir)).To(Equal(strings.TrimSpace(string(commit))))
+		})
+
+		It("should use a digest of the files of a buildpack that isn't a git checkout", func() {
+			os.MkdirAll(buildpackDir+"/testbuildpack/bin", 0755)
+			ioutil.WriteFile(buildpackDir+"/testbuildpack/bin/detect", []byte("#!/bin/bash"), 0755)
+			version, err := buildpack.Version("testbuildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			ioutil.WriteFile(buildpackDir+"/testbuildpack/bin/detect", []byte("#!/bin/bash\nexit 1"), 0755)
+			Expect(buildpack.Version("testbuildpack", buildpackDir)).ToNot(Equal(version))
+		})
+
+		It("should use a digest of a buildpack that isn't a git checkout but is inside one", func() {
+			os.MkdirAll(buildpackDir+"/testbuildpack/bin", 0755)
+			ioutil.WriteFile(buildpackDir+"/testbuildpack/bin/detect", []byte("#!/bin/bash"), 0755)
+			for _, args := range [][]string{
+				{"init", "-q"},
+				{"add", "."},
+				{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "test"},
+			} {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = buildpackDir
+				Expect(cmd.Run()).ShouldNot(HaveOccurred())
+			}
+			version, err := buildpack.Version("testbuildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			digest, _ := appfiles.DigestDir(buildpackDir + "/testbuildpack")
+			Expect(version).To(Equal(digest))
+			ioutil.WriteFile(buildpackDir+"/testbuildpack/bin/detect", []byte("#!/bin/bash\nexit 1"), 0755)
+			Expect(buildpack.Version("testbuildpack", buildpackDir)).ToNot(Equal(version))
+		})
+	})
+
+	Describe("Choosing a buildpack", func() {
+		It("should tell installed buildpacks and buildpack URLs apart", func() {
+			os.Mkdir(buildpackDir+"/ruby-buildpack", 0755)
+			Expect(buildpack.Installed("ruby-buildpack", buildpackDir)).To(Equal(true))
+			Expect(buildpack.Installed("php-buildpack", buildpackDir)).To(Equal(false))
+			Expect(buildpack.Installed("../ruby-buildpack", buildpackDir)).To(Equal(false))
+			Expect(buildpack.IsURL("https://github.com/cloudfoundry/ruby-buildpack")).To(Equal(true))
+			Expect(buildpack.IsURL("ruby-buildpack")).To(Equal(false))
+		})
 	})
 })
@@ -0,0 +1,144 @@
+package buildpack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Metadata is what Cloud Rocker records about an installed buildpack when it is added or updated
+type Metadata struct {
+	Name        string    `json:"name"`
+	Source      string    `json:"source"`
+	Commit      string    `json:"commit,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	InstalledAt time.Time `json:"installed_at"`
+	Linked      bool      `json:"linked,omitempty"`
+}
+
+// Records are kept beside the buildpacks, in a hidden directory that isn't taken for a buildpack
+const metadataDir = ".metadata"
+
+// ReadMetadata returns what was recorded about a buildpack. A linked buildpack's commit and version are read
+// afresh, as it can change without Cloud Rocker knowing, as is everything about a buildpack installed before
+// Cloud Rocker kept records, whose source isn't known
+func ReadMetadata(buildpack string, buildpackDir string) (*Metadata, error) {
+	if !Installed(buildpack, buildpackDir) {
+		return nil, fmt.Errorf("Buildpack %s is not installed", buildpack)
+	}
+	metadata := new(Metadata)
+	metadataBytes, err := ioutil.ReadFile(metadataFile(buildpack, buildpackDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(metadataBytes, metadata); err != nil {
+			return nil, fmt.Errorf("Failed to parse the metadata of buildpack %s: %s", buildpack, err)
+		}
+		if !metadata.Linked {
+			return metadata, nil
+		}
+	} else {
+		info, err := os.Stat(buildpackDir + "/" + buildpack)
+		if err != nil {
+			return nil, err
+		}
+		metadata.Name = buildpack
+		metadata.InstalledAt = info.ModTime()
+		metadata.Linked = Linked(buildpack, buildpackDir)
+	}
+	metadata.Commit, metadata.Version = currentVersion(buildpackDir + "/" + buildpack)
+	return metadata, nil
+}
+
+func recordInstall(buildpack string, source string, buildpackDir string) error {
+	metadata := &Metadata{
+		Name:        buildpack,
+		Source:      source,
+		InstalledAt: time.Now(),
+		Linked:      Linked(buildpack, buildpackDir),
+	}
+	metadata.Commit, metadata.Version = currentVersion(buildpackDir + "/" + buildpack)
+	if err := os.MkdirAll(buildpackDir+"/"+metadataDir, 0755); err != nil {
+		return err
+	}
+	metadataBytes, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metadataFile(buildpack, buildpackDir), metadataBytes, 0644)
+}
+
+func forgetInstall(buildpack string, buildpackDir string) error {
+	return os.RemoveAll(metadataFile(buildpack, buildpackDir))
+}
+
+func metadataFile(buildpack string, buildpackDir string) string {
+	return buildpackDir + "/" + metadataDir + "/" + buildpack + ".json"
+}
+
+// currentVersion is the commit a buildpack's own git checkout is at, and the version in its VERSION file, as Cloud
+// Foundry's buildpacks have - either can be empty
+func currentVersion(dir string) (string, string) {
+	version, _ := ioutil.ReadFile(dir + "/VERSION")
+	return gitCommit(dir), strings.TrimSpace(string(version))
+}
+
+// ListVerbose shows the buildpacks in the order they are detected in, with what is known about each
+func ListVerbose(writer io.Writer, buildpackDir string) error {
+	buildpacks, err := DetectOrder(buildpackDir)
+	if err != nil {
+		return err
+	}
+	if len(buildpacks) == 0 {
+		fmt.Fprintln(writer, "No buildpacks installed")
+		return nil
+	}
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "POSITION\tNAME\tVERSION\tCOMMIT\tINSTALLED\tSOURCE")
+	for position, buildpack := range buildpacks {
+		metadata, err := ReadMetadata(buildpack, buildpackDir)
+		if err != nil {
+			return err
+		}
+		source := orUnknown(metadata.Source)
+		if metadata.Linked {
+			source += " (linked)"
+		}
+		fmt.Fprintf(tabWriter, "%d\t%s\t%s\t%s\t%s\t%s\n", position+1, buildpack, orUnknown(metadata.Version),
+			orUnknown(shortCommit(metadata.Commit)), metadata.InstalledAt.Format("2006-01-02 15:04"), source)
+	}
+	return tabWriter.Flush()
+}
+
+// Describe gives the buildpack's version and commit, as far as they are known
+func (metadata *Metadata) Describe() string {
+	switch {
+	case metadata.Version != "" && metadata.Commit != "":
+		return metadata.Version + " (" + shortCommit(metadata.Commit) + ")"
+	case metadata.Version != "":
+		return metadata.Version
+	case metadata.Commit != "":
+		return shortCommit(metadata.Commit)
+	}
+	return "an unknown version"
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 10 {
+		return commit[:10]
+	}
+	return commit
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,153 @@
+package buildpack_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/cloudcredo/cloudrocker/buildpack"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Buildpack metadata", func() {
+	var (
+		buffer       *gbytes.Buffer
+		tmpDir       string
+		buildpackDir string
+		sourceDir    string
+	)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = sourceDir
+		output, err := cmd.CombinedOutput()
+		Expect(err).ShouldNot(HaveOccurred(), string(output))
+		return strings.TrimSpace(string(output))
+	}
+
+	BeforeEach(func() {
+		buffer = gbytes.NewBuffer()
+		tmpDir, _ = ioutil.TempDir(os.TempDir(), "rocker-buildpack-metadata-test")
+		buildpackDir = tmpDir + "/buildpacks"
+		sourceDir = tmpDir + "/my-buildpack"
+		os.MkdirAll(sourceDir+"/bin", 0755)
+		ioutil.WriteFile(sourceDir+"/bin/detect", []byte("#!/bin/bash"), 0755)
+		ioutil.WriteFile(sourceDir+"/VERSION", []byte("1.0.0\n"), 0644)
+		git("init", "-q")
+		git("add", ".")
+		git("commit", "-q", "-m", "1.0.0")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("Recording a buildpack", func() {
+		It("should record where the buildpack came from and which version it is", func() {
+			err := buildpack.Add(buffer, "file://"+sourceDir, buildpackDir, buildpack.AddOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+			metadata, err := buildpack.ReadMetadata("my-buildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(metadata.Source).To(Equal("file://" + sourceDir))
+			Expect(metadata.Commit).To(Equal(git("rev-parse", "HEAD")))
+			Expect(metadata.Version).To(Equal("1.0.0"))
+			Expect(metadata.InstalledAt.IsZero()).To(Equal(false))
+			Expect(metadata.Describe()).To(Equal("1.0.0 (" + git("rev-parse", "HEAD")[:10] + ")"))
+		})
+
+		It("should describe a buildpack installed without a record from the buildpack itself", func() {
+			os.MkdirAll(buildpackDir, 0755)
+			exec.Command("cp", "-a", sourceDir, buildpackDir+"/old-buildpack").Run()
+			metadata, err := buildpack.ReadMetadata("old-buildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(metadata.Source).To(Equal(""))
+			Expect(metadata.Version).To(Equal("1.0.0"))
+			Expect(metadata.Commit).To(Equal(git("rev-parse", "HEAD")))
+		})
+
+		It("should not take the commit of a repository a buildpack without one of its own is inside", func() {
+			os.MkdirAll(buildpackDir, 0755)
+			exec.Command("cp", "-a", sourceDir, buildpackDir+"/zipped-buildpack").Run()
+			os.RemoveAll(buildpackDir + "/zipped-buildpack/.git")
+			sourceDir = tmpDir
+			git("init", "-q")
+			git("add", "buildpacks")
+			git("commit", "-q", "-m", "dotfiles")
+			metadata, err := buildpack.ReadMetadata("zipped-buildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(metadata.Commit).To(Equal(""))
+			Expect(metadata.Version).To(Equal("1.0.0"))
+		})
+
+		It("should forget a deleted buildpack", func() {
+			buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{})
+			buildpack.Delete(buffer, "my-buildpack", buildpackDir)
+			_, err := os.Stat(buildpackDir + "/.metadata/my-buildpack.json")
+			Expect(os.IsNotExist(err)).To(Equal(true))
+		})
+
+		It("should list the buildpacks in detect order with their metadata", func() {
+			buildpack.Add(buffer, "file://"+sourceDir, buildpackDir, buildpack.AddOptions{Name: "b-buildpack"})
+			buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{Name: "a-buildpack", Link: true})
+			err := buildpack.ListVerbose(buffer, buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(buffer).Should(gbytes.Say(`POSITION\s+NAME\s+VERSION\s+COMMIT\s+INSTALLED\s+SOURCE`))
+			Eventually(buffer).Should(gbytes.Say(`1\s+a-buildpack\s+1.0.0\s+\w{10}\s+\d{4}-\d\d-\d\d \d\d:\d\d\s+/.*/my-buildpack \(linked\)`))
+			Eventually(buffer).Should(gbytes.Say(`2\s+b-buildpack\s+1.0.0\s+\w{10}\s+\d{4}-\d\d-\d\d \d\d:\d\d\s+file:///.*/my-buildpack`))
+		})
+	})
+
+	Describe("Updating a buildpack", func() {
+		BeforeEach(func() {
+			err := buildpack.Add(buffer, "file://"+sourceDir, buildpackDir, buildpack.AddOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should fetch the latest version from the buildpack's source", func() {
+			ioutil.WriteFile(sourceDir+"/VERSION", []byte("1.1.0\n"), 0644)
+			git("commit", "-q", "-a", "-m", "1.1.0")
+			err := buildpack.Update(buffer, "my-buildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(buffer).Should(gbytes.Say(`Updated buildpack my-buildpack from 1.0.0 \(\w{10}\) to 1.1.0 \(\w{10}\).`))
+			metadata, _ := buildpack.ReadMetadata("my-buildpack", buildpackDir)
+			Expect(metadata.Commit).To(Equal(git("rev-parse", "HEAD")))
+			Expect(metadata.Source).To(Equal("file://" + sourceDir))
+		})
+
+		It("should say when the buildpack is up to date", func() {
+			err := buildpack.Update(buffer, "my-buildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(buffer).Should(gbytes.Say(`Buildpack my-buildpack is up to date at 1.0.0 \(\w{10}\).`))
+		})
+
+		It("should leave the buildpack as it was if its source has gone", func() {
+			os.RemoveAll(sourceDir)
+			err := buildpack.Update(buffer, "my-buildpack", buildpackDir)
+			Expect(err).Should(HaveOccurred())
+			metadata, err := buildpack.ReadMetadata("my-buildpack", buildpackDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(metadata.Version).To(Equal("1.0.0"))
+			Expect(buildpack.DetectOrder(buildpackDir)).To(Equal([]string{"my-buildpack"}))
+		})
+
+		It("should refuse to update a buildpack whose source isn't known", func() {
+			exec.Command("cp", "-a", sourceDir, buildpackDir+"/old-buildpack").Run()
+			err := buildpack.Update(buffer, "old-buildpack", buildpackDir)
+			Expect(err).Should(MatchError("The source of buildpack old-buildpack wasn't recorded - delete it and add it again to be able to update it"))
+		})
+
+		It("should update every buildpack, carrying on past failures", func() {
+			exec.Command("cp", "-a", sourceDir, buildpackDir+"/old-buildpack").Run()
+			buildpack.Add(buffer, sourceDir, buildpackDir, buildpack.AddOptions{Name: "linked-buildpack", Link: true})
+			err := buildpack.UpdateAll(buffer, buildpackDir)
+			Expect(err).Should(MatchError("Failed to update buildpacks old-buildpack"))
+			Eventually(buffer).Should(gbytes.Say("Buildpack linked-buildpack is linked, so it is always up to date."))
+			Eventually(buffer).Should(gbytes.Say("Buildpack my-buildpack is up to date"))
+			Eventually(buffer).Should(gbytes.Say("The source of buildpack old-buildpack wasn't recorded"))
+		})
+	})
+})
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The names of the service instances bound to an application are kept in its own directory
+func ReadBoundServices(appHomeDir string) ([]string, error) {
+	names := []string{}
+	namesBytes, err := ioutil.ReadFile(appHomeDir + "/services.json")
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(namesBytes, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func WriteBoundServices(appHomeDir string, names []string) error {
+	if err := os.MkdirAll(appHomeDir, 0755); err != nil {
+		return err
+	}
+	sort.Strings(names)
+	namesBytes, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appHomeDir+"/services.json", namesBytes, 0644)
+}
+
+// AppsBoundTo finds the applications under CLOUDROCKER_HOME that a service instance is bound to
+func AppsBoundTo(cloudRockerHomeDir string, name string) ([]string, error) {
+	apps := []string{}
+	appHomeDirs, err := filepath.Glob(cloudRockerHomeDir + "/apps/*")
+	if err != nil {
+		return nil, err
+	}
+	for _, appHomeDir := range appHomeDirs {
+		names, err := ReadBoundServices(appHomeDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, boundName := range names {
+			if boundName == name {
+				apps = append(apps, filepath.Base(appHomeDir))
+			}
+		}
+	}
+	sort.Strings(apps)
+	return apps, nil
+}
@@ -5,9 +5,12 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"strings"
+	"sort"
+	"strconv"
 
-	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/pivotal-golang/bytefmt"
+	"github.com/cloudcredo/cloudrocker/buildpack"
+	"github.com/cloudcredo/cloudrocker/service"
 )
 
 type ContainerConfig struct {
@@ -21,8 +24,33 @@ type ContainerConfig struct {
 	Command        []string
 	DropletDir     string
 	BaseConfigDir  string
+	MemoryLimit    int64
+	DiskLimit      int64
+	ImageUser      bool
+	Network        string
+	Links          map[string]string
 }
 
+// NetworkName is the user-defined Docker network that staging, runtime and service containers share, so they can
+// find each other by name instead of by a bridge IP that differs between hosts. It is one network rather than one
+// per application because service instances are shared: like services in a Cloud Foundry space, one instance can
+// be bound to several applications, and a link only works between containers on the same network
+const NetworkName = "cloudrocker"
+
+// TmpVolumeName is the size-limited tmpfs volume a runtime container gets as its TMPDIR, which holds the
+// application to its disk_quota
+func TmpVolumeName(containerName string) string {
+	return containerName + "-tmp"
+}
+
+// Stack is the root filesystem applications are staged and run on
+const Stack = "cflinuxfs2"
+
+// DepsDir is where a multi-buildpack droplet keeps what its supply buildpacks installed. It sits inside the
+// droplet's app directory, so the runtime's existing mount of that directory brings it along, and staging
+// mounts the deps directory at the same path
+const DepsDir = ".deps"
+
 func NewBaseContainerConfig(baseConfigDir string) (containerConfig *ContainerConfig) {
 	containerConfig = &ContainerConfig{
 		SrcImageTag:   "cloudrocker-raw:latest",
@@ -32,21 +60,85 @@ func NewBaseContainerConfig(baseConfigDir string) (containerConfig *ContainerCon
 	return
 }
 
-func NewStageContainerConfig(directories *Directories) (containerConfig *ContainerConfig) {
+func NewStageContainerConfig(directories *Directories, application *Application) (containerConfig *ContainerConfig) {
+	command := append([]string{"/rocker/rock", "stage", "internal"}, application.ChosenBuildpacks()...)
+
+	mounts := directories.Mounts()
+	//linked buildpacks are symlinks, which only resolve if what they point to is at the same path in the container
+	if linkedDirs, err := buildpack.LinkedDirs(directories.Buildpacks()); err == nil {
+		for _, dir := range linkedDirs {
+			mounts[dir] = dir
+		}
+	}
+
 	containerConfig = &ContainerConfig{
-		ContainerName: "cloudrocker-staging",
-		Mounts:        directories.Mounts(),
-		//This is a hack until we follow the one true way for env vars
-		EnvVars: map[string]string{
-			"CF_STACK": "cflinuxfs2",
-		},
-		SrcImageTag: "cloudrocker-base:latest",
-		Command:     []string{"/rocker/rock", "stage", "internal"},
+		ContainerName: application.StagingContainerName(),
+		Mounts:        mounts,
+		EnvVars:       StagingEnvironment(application).EnvVars(),
+		SrcImageTag:   "cloudrocker-base:latest",
+		Command:       command,
+		Network:       NetworkName,
 	}
 	return
 }
 
-func NewRuntimeContainerConfig(dropletDir string, dstImageTagOptional ...string) (containerConfig *ContainerConfig) {
+// Service containers run as their image's own user, and publish the service's port on a host port. Applications
+// reach them over the shared network instead, as the hostname they are linked with
+func NewServiceContainerConfig(containerName string, image string, port int, hostPort int, envVars map[string]string, command []string) (containerConfig *ContainerConfig) {
+	containerConfig = &ContainerConfig{
+		ContainerName:  containerName,
+		Daemon:         true,
+		Mounts:         map[string]string{},
+		PublishedPorts: map[int]int{port: hostPort},
+		EnvVars:        envVars,
+		SrcImageTag:    image,
+		Command:        command,
+		ImageUser:      true,
+		Network:        NetworkName,
+	}
+	return
+}
+
+// A web instance is published on its host port; other process types run without a published port
+type Instance struct {
+	Index          int
+	HostPort       int
+	HostPortSource string
+	Process        string
+}
+
+// Only the staging environment has the staging env group, proxy settings and staging env vars, so they stay out of
+// the droplet, the runtime container and built images
+func StagingEnvironment(application *Application) Environment {
+	environment := Environment{}
+	environment.SetAll(application.StagingEnvGroup, StagingGroupSource)
+	environment.SetAll(application.ProxyEnv, ProxySource)
+	environment.SetAll(application.Env, ManifestSource)
+	environment.SetAll(application.UserEnv, UserSource)
+	environment.SetAll(application.StagingEnv, StagingSource)
+	setStagingApplicationEnvVars(environment, application)
+	environment.Set("CF_STACK", Stack, DefaultSource)
+	return environment
+}
+
+func RuntimeEnvironment(dropletDir string, application *Application, instance Instance) Environment {
+	environment := Environment{}
+	environment.SetAll(application.Env, ManifestSource)
+	environment.SetAll(application.UserEnv, UserSource)
+	setRuntimeApplicationEnvVars(environment, application, instance)
+	environment.Set("HOME", "/app", DefaultSource)
+	environment.Set("TMPDIR", "/app/tmp", DefaultSource)
+	environment.Set("PORT", strconv.Itoa(containerPort), DefaultSource)
+	if _, err := os.Stat(dropletDir + "/app/" + DepsDir); err == nil {
+		environment.Set("DEPS_DIR", "/app/"+DepsDir, DefaultSource)
+	}
+	services, servicesSource := vcapServices(dropletDir, application.ServiceBindings)
+	environment.Set("VCAP_SERVICES", services, servicesSource)
+	environment.Set("DATABASE_URL", databaseURL(services), servicesSource)
+	return environment
+}
+
+func NewRuntimeContainerConfig(dropletDir string, application *Application, instance Instance, dstImageTagOptional ...string) (containerConfig *ContainerConfig) {
 	var dstImageTag string
 	if dstImageTagOptional == nil {
 		dstImageTag = "cloudrocker-build:latest"
@@ -54,97 +146,109 @@ func NewRuntimeContainerConfig(dropletDir string, dstImageTagOptional ...string)
 		dstImageTag = dstImageTagOptional[0]
 	}
 
+	containerName := application.ProcessContainerName(instance.Process, instance.Index)
 	containerConfig = &ContainerConfig{
-		ContainerName: "cloudrocker-runtime",
+		ContainerName: containerName,
 		Daemon:        true,
 		Mounts: map[string]string{
-			dropletDir + "/app": "/app",
-		},
-		PublishedPorts: map[int]int{8080: 8080},
-		EnvVars: map[string]string{
-			"HOME":          "/app",
-			"TMPDIR":        "/app/tmp",
-			"PORT":          "8080",
-			"VCAP_SERVICES": vcapServices(dropletDir),
-			"DATABASE_URL":  databaseURL(dropletDir),
+			dropletDir + "/app":          "/app",
+			TmpVolumeName(containerName): "/app/tmp",
 		},
-		SrcImageTag: "cloudrocker-base:latest",
-		DstImageTag: dstImageTag,
+		PublishedPorts: publishedPorts(instance),
+		EnvVars:        RuntimeEnvironment(dropletDir, application, instance).EnvVars(),
+		SrcImageTag:    "cloudrocker-base:latest",
+		DstImageTag:    dstImageTag,
 		Command: append([]string{"/bin/bash", "/app/cloudrocker-start-1c4352a23e52040ddb1857d7675fe3cc.sh", "/app"},
-			parseStartCommand(dropletDir)...),
-		DropletDir: dropletDir,
+			startCommand(dropletDir, application, instance.Process)...),
+		DropletDir:  dropletDir,
+		MemoryLimit: limitBytes(application.Memory, DefaultMemory),
+		DiskLimit:   limitBytes(application.DiskQuota, DefaultDiskQuota),
+		Network:     NetworkName,
+		Links:       application.ServiceLinks,
 	}
 	return
 }
 
-func vcapServices(dropletDir string) (services string) {
+// Limits fall back to the same defaults VCAP_APPLICATION and MEMORY_LIMIT advertise, so an application that sizes
+// itself from them is held to what it was told
+func limitBytes(quantity string, defaultQuantity string) int64 {
+	return int64(megabytes(quantity, defaultQuantity) * bytefmt.MEGABYTE)
+}
+
+func publishedPorts(instance Instance) map[int]int {
+	if instance.HostPort == 0 {
+		return map[int]int{}
+	}
+	return map[int]int{containerPort: instance.HostPort}
+}
+
+func startCommand(dropletDir string, application *Application, process string) []string {
+	command, err := dropletStartCommand(dropletDir, application, process)
+	if err != nil {
+		log.Fatalf(" %s", err)
+	}
+	return command
+}
+
+// Bound service instances make up VCAP_SERVICES, falling back to the application's own vcap_services.json
+func vcapServices(dropletDir string, bindings []service.Binding) (string, string) {
+	if len(bindings) > 0 {
+		services := make(map[string][]service.Binding)
+		for _, binding := range bindings {
+			services[binding.Label] = append(services[binding.Label], binding)
+		}
+		return toJSON(services), BindingsSource
+	}
 	servicesBytes, err := ioutil.ReadFile(dropletDir + "/app/vcap_services.json")
 	if err != nil {
-		return
+		return "", ServicesSource
 	}
-	services = string(servicesBytes)
-	return
+	return string(servicesBytes), ServicesSource
 }
 
 type database struct {
+	Tags        []string
 	Credentials struct {
 		URI string
 	}
 }
 
-func databaseURL(dropletDir string) (databaseURL string) {
-	servicesBytes, err := ioutil.ReadFile(dropletDir + "/app/vcap_services.json")
-	if err != nil {
+// A relational database's URI is preferred, otherwise the first service with a URI is used
+func databaseURL(vcapServices string) (databaseURL string) {
+	var services map[string][]database
+
+	if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
 		return
 	}
 
-	var services map[string][]database
-
-	json.Unmarshal(servicesBytes, &services)
+	labels := []string{}
+	for label := range services {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
 
-	for _, serviceDatabase := range services {
-		if len(serviceDatabase) > 0 && serviceDatabase[0].Credentials.URI != "" {
-			databaseURL = serviceDatabase[0].Credentials.URI
+	for _, label := range labels {
+		for _, serviceDatabase := range services[label] {
+			if serviceDatabase.Credentials.URI == "" {
+				continue
+			}
+			if isRelational(serviceDatabase) {
+				return serviceDatabase.Credentials.URI
+			}
+			if databaseURL == "" {
+				databaseURL = serviceDatabase.Credentials.URI
+			}
 		}
 	}
 
 	return
 }
 
-type StagingInfoYml struct {
-	DetectedBuildpack string `yaml:"detected_buildpack"`
-	StartCommand      string `yaml:"start_command"`
-}
-
-type ProcfileYml struct {
-	Web string `yaml:"web"`
-}
-
-func parseStartCommand(dropletDir string) (startCommand []string) {
-	stagingInfoFile, err := os.Open(dropletDir + "/staging_info.yml")
-	if err == nil {
-		stagingInfo := new(StagingInfoYml)
-		decoder := candiedyaml.NewDecoder(stagingInfoFile)
-		err = decoder.Decode(stagingInfo)
-		if err != nil {
-			log.Fatalf("Failed to decode document: %s", err)
-		}
-		startCommand = strings.Split(stagingInfo.StartCommand, " ")
-		if startCommand[0] != "" {
-			return
-		}
-		procfileFile, err := os.Open(dropletDir + "/app/Procfile")
-		if err == nil {
-			procfileInfo := new(ProcfileYml)
-			decoder := candiedyaml.NewDecoder(procfileFile)
-			err = decoder.Decode(procfileInfo)
-			if err != nil {
-				log.Fatalf("Failed to decode document: %s", err)
-			}
-			startCommand = strings.Split(procfileInfo.Web, " ")
-			return
+func isRelational(serviceDatabase database) bool {
+	for _, tag := range serviceDatabase.Tags {
+		if tag == "relational" {
+			return true
 		}
 	}
-	log.Fatal("Unable to find staging_info.yml")
-	return
+	return false
 }
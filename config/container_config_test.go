@@ -1,6 +1,9 @@
 package config_test
 
 import (
+	"io/ioutil"
+	"os"
+
 	"github.com/cloudcredo/cloudrocker/config"
 
 	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
@@ -10,15 +13,64 @@ import (
 var _ = Describe("ContainerConfig", func() {
 	Describe("Generating a ContainerConfig for staging", func() {
 		It("should return a valid ContainerConfig with the correct staging information", func() {
-			stageConfig := config.NewStageContainerConfig(config.NewDirectories("TEST_CLOUDROCKERHOME"))
-			Expect(stageConfig.ContainerName).To(Equal("cloudrocker-staging"))
-			Expect(stageConfig.Mounts["TEST_CLOUDROCKERHOME/staging"]).To(Equal("/tmp/app"))
-			Expect(stageConfig.Mounts["TEST_CLOUDROCKERHOME/tmp"]).To(Equal("/tmp"))
+			stageConfig := config.NewStageContainerConfig(config.NewDirectories("TEST_CLOUDROCKERHOME", "app"), config.NewApplication("/test/app"))
+			Expect(stageConfig.ContainerName).To(Equal("cloudrocker-staging-app"))
+			Expect(stageConfig.Mounts["TEST_CLOUDROCKERHOME/apps/app/staging"]).To(Equal("/tmp/app"))
+			Expect(stageConfig.Mounts["TEST_CLOUDROCKERHOME/apps/app/tmp"]).To(Equal("/tmp"))
 			Expect(stageConfig.Mounts["TEST_CLOUDROCKERHOME/buildpacks"]).To(Equal("/cloudrockerbuildpacks"))
 			Expect(stageConfig.Mounts["TEST_CLOUDROCKERHOME/rocker"]).To(Equal("/rocker"))
 			Expect(stageConfig.EnvVars["CF_STACK"]).To(Equal("cflinuxfs2"))
 			Expect(stageConfig.SrcImageTag).To(Equal("cloudrocker-base:latest"))
 			Expect(stageConfig.Command).To(Equal([]string{"/rocker/rock", "stage", "internal"}))
+			Expect(stageConfig.Network).To(Equal("cloudrocker"))
+		})
+
+		It("should mount the directories linked buildpacks point to at the same path", func() {
+			cloudrockerHome, _ := ioutil.TempDir(os.TempDir(), "config-test-linked-buildpack")
+			defer os.RemoveAll(cloudrockerHome)
+			os.MkdirAll(cloudrockerHome+"/dev/my-buildpack", 0755)
+			os.MkdirAll(cloudrockerHome+"/buildpacks", 0755)
+			os.Symlink(cloudrockerHome+"/dev/my-buildpack", cloudrockerHome+"/buildpacks/my-buildpack")
+			stageConfig := config.NewStageContainerConfig(config.NewDirectories(cloudrockerHome, "app"), config.NewApplication("/test/app"))
+			Expect(stageConfig.Mounts[cloudrockerHome+"/buildpacks"]).To(Equal("/cloudrockerbuildpacks"))
+			Expect(stageConfig.Mounts[cloudrockerHome+"/dev/my-buildpack"]).To(Equal(cloudrockerHome + "/dev/my-buildpack"))
+		})
+
+		Context("with a manifest application", func() {
+			It("should pass the manifest's buildpack and env vars to the staging container", func() {
+				manifest, _ := config.ParseManifest("fixtures/manifestapp")
+				stageConfig := config.NewStageContainerConfig(config.NewDirectories("TEST_CLOUDROCKERHOME", "app"), manifest.Application("fixtures/manifestapp"))
+				Expect(stageConfig.EnvVars["CF_STACK"]).To(Equal("cflinuxfs2"))
+				Expect(stageConfig.EnvVars["RACK_ENV"]).To(Equal("development"))
+				Expect(stageConfig.EnvVars["TIMEOUT"]).To(Equal("30"))
+				Expect(stageConfig.EnvVars["MEMORY_LIMIT"]).To(Equal("512m"))
+				Expect(stageConfig.Command).To(Equal([]string{"/rocker/rock", "stage", "internal", "https://github.com/cloudfoundry/ruby-buildpack"}))
+			})
+
+			It("should pass the staging env group, host proxy and staging env vars to the staging container only", func() {
+				manifest, _ := config.ParseManifest("fixtures/manifestapp")
+				application := manifest.Application("fixtures/manifestapp")
+				application.StagingEnvGroup = map[string]string{"NPM_CONFIG_REGISTRY": "https://npm.example.com", "RACK_ENV": "production"}
+				application.ProxyEnv = map[string]string{"HTTPS_PROXY": "http://proxy.example.com:3128"}
+				application.StagingEnv = map[string]string{"BUNDLE_GEMS__EXAMPLE__COM": "token", "CF_STACK": "trusty"}
+				stageConfig := config.NewStageContainerConfig(config.NewDirectories("TEST_CLOUDROCKERHOME", "app"), application)
+				Expect(stageConfig.EnvVars["NPM_CONFIG_REGISTRY"]).To(Equal("https://npm.example.com"))
+				Expect(stageConfig.EnvVars["RACK_ENV"]).To(Equal("development"))
+				Expect(stageConfig.EnvVars["HTTPS_PROXY"]).To(Equal("http://proxy.example.com:3128"))
+				Expect(stageConfig.EnvVars["BUNDLE_GEMS__EXAMPLE__COM"]).To(Equal("token"))
+				Expect(stageConfig.EnvVars["CF_STACK"]).To(Equal("cflinuxfs2"))
+
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/testdroplet", application, config.Instance{Index: 0, HostPort: 8080})
+				Expect(runtimeConfig.EnvVars).ToNot(HaveKey("NPM_CONFIG_REGISTRY"))
+				Expect(runtimeConfig.EnvVars).ToNot(HaveKey("HTTPS_PROXY"))
+				Expect(runtimeConfig.EnvVars).ToNot(HaveKey("BUNDLE_GEMS__EXAMPLE__COM"))
+			})
+
+			It("should pass every buildpack of a multi-buildpack application to the staging container", func() {
+				manifest, _ := config.ParseManifest("fixtures/multibuildpackapp")
+				stageConfig := config.NewStageContainerConfig(config.NewDirectories("TEST_CLOUDROCKERHOME", "app"), manifest.Application("fixtures/multibuildpackapp"))
+				Expect(stageConfig.Command).To(Equal([]string{"/rocker/rock", "stage", "internal", "binary_buildpack", "python_buildpack"}))
+			})
 		})
 	})
 
@@ -26,13 +78,14 @@ var _ = Describe("ContainerConfig", func() {
 		Context("without a destination image tag", func() {
 			Context("with a valid staging_info.yml", func() {
 				It("should return a valid ContainerConfig with the correct runtime information", func() {
-					runtimeConfig := config.NewRuntimeContainerConfig("fixtures/testdroplet")
-					Expect(runtimeConfig.ContainerName).To(Equal("cloudrocker-runtime"))
+					runtimeConfig := config.NewRuntimeContainerConfig("fixtures/testdroplet", config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080})
+					Expect(runtimeConfig.ContainerName).To(Equal("cloudrocker-runtime-app"))
 					Expect(runtimeConfig.Daemon).To(Equal(true))
-					Expect(len(runtimeConfig.Mounts)).To(Equal(1))
+					Expect(len(runtimeConfig.Mounts)).To(Equal(2))
 					Expect(runtimeConfig.Mounts["fixtures/testdroplet/app"]).To(Equal("/app"))
+					Expect(runtimeConfig.Mounts["cloudrocker-runtime-app-tmp"]).To(Equal("/app/tmp"))
 					Expect(runtimeConfig.PublishedPorts).To(Equal(map[int]int{8080: 8080}))
-					Expect(len(runtimeConfig.EnvVars)).To(Equal(5))
+					Expect(len(runtimeConfig.EnvVars)).To(Equal(15))
 					Expect(runtimeConfig.EnvVars["HOME"]).To(Equal("/app"))
 					Expect(runtimeConfig.EnvVars["PORT"]).To(Equal("8080"))
 					Expect(runtimeConfig.EnvVars["TMPDIR"]).To(Equal("/app/tmp"))
@@ -44,17 +97,19 @@ var _ = Describe("ContainerConfig", func() {
 						"/app",
 						"bundle", "exec", "rackup", "config.ru", "-p", "$PORT"}))
 					Expect(runtimeConfig.DropletDir).To(Equal("fixtures/testdroplet"))
+					Expect(runtimeConfig.MemoryLimit).To(Equal(int64(1024 * 1024 * 1024)))
+					Expect(runtimeConfig.DiskLimit).To(Equal(int64(1024 * 1024 * 1024)))
 				})
 			})
 			Context("with no staging_info.yml, but a valid Procfile", func() {
 				It("should return a valid ContainerConfig with the correct runtime information", func() {
-					runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet")
-					Expect(runtimeConfig.ContainerName).To(Equal("cloudrocker-runtime"))
+					runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080})
+					Expect(runtimeConfig.ContainerName).To(Equal("cloudrocker-runtime-app"))
 					Expect(runtimeConfig.Daemon).To(Equal(true))
-					Expect(len(runtimeConfig.Mounts)).To(Equal(1))
+					Expect(len(runtimeConfig.Mounts)).To(Equal(2))
 					Expect(runtimeConfig.Mounts["fixtures/procfiletestdroplet/app"]).To(Equal("/app"))
 					Expect(runtimeConfig.PublishedPorts).To(Equal(map[int]int{8080: 8080}))
-					Expect(len(runtimeConfig.EnvVars)).To(Equal(5))
+					Expect(len(runtimeConfig.EnvVars)).To(Equal(15))
 					Expect(runtimeConfig.EnvVars["HOME"]).To(Equal("/app"))
 					Expect(runtimeConfig.EnvVars["TMPDIR"]).To(Equal("/app/tmp"))
 					Expect(runtimeConfig.EnvVars["PORT"]).To(Equal("8080"))
@@ -68,10 +123,108 @@ var _ = Describe("ContainerConfig", func() {
 						"server"}))
 				})
 			})
+			Context("with a multi-buildpack droplet", func() {
+				It("should point DEPS_DIR at the dependencies the buildpacks supplied", func() {
+					runtimeConfig := config.NewRuntimeContainerConfig("fixtures/multibuildpackdroplet", config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080})
+					Expect(len(runtimeConfig.Mounts)).To(Equal(2))
+					Expect(runtimeConfig.EnvVars["DEPS_DIR"]).To(Equal("/app/.deps"))
+					Expect(runtimeConfig.Command).To(Equal([]string{"/bin/bash",
+						"/app/cloudrocker-start-1c4352a23e52040ddb1857d7675fe3cc.sh",
+						"/app",
+						"python", "app.py"}))
+				})
+
+				It("should leave DEPS_DIR out of a single buildpack droplet", func() {
+					runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080})
+					Expect(runtimeConfig.EnvVars).ShouldNot(HaveKey("DEPS_DIR"))
+				})
+			})
 		})
+		Context("with a manifest application", func() {
+			var application *config.Application
+
+			BeforeEach(func() {
+				manifest, _ := config.ParseManifest("fixtures/manifestapp")
+				application = manifest.Application("fixtures/manifestapp")
+				application.Command = "bundle exec rackup config.ru"
+			})
+
+			It("should use the manifest's command, env vars and memory", func() {
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", application, config.Instance{Index: 0, HostPort: 8080})
+				Expect(runtimeConfig.EnvVars["RACK_ENV"]).To(Equal("development"))
+				Expect(runtimeConfig.EnvVars["TIMEOUT"]).To(Equal("30"))
+				Expect(runtimeConfig.EnvVars["MEMORY_LIMIT"]).To(Equal("512m"))
+				Expect(runtimeConfig.EnvVars["PORT"]).To(Equal("8080"))
+				Expect(runtimeConfig.MemoryLimit).To(Equal(int64(512 * 1024 * 1024)))
+				Expect(runtimeConfig.Command).To(Equal([]string{"/bin/bash",
+					"/app/cloudrocker-start-1c4352a23e52040ddb1857d7675fe3cc.sh",
+					"/app",
+					"bundle", "exec", "rackup", "config.ru"}))
+			})
+
+			It("should let the memory be overridden, with matching env vars", func() {
+				Expect(application.SetMemory("2G")).ShouldNot(HaveOccurred())
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", application, config.Instance{Index: 0, HostPort: 8080})
+				Expect(runtimeConfig.MemoryLimit).To(Equal(int64(2 * 1024 * 1024 * 1024)))
+				Expect(runtimeConfig.EnvVars["MEMORY_LIMIT"]).To(Equal("2048m"))
+			})
+
+			It("should limit the application's temporary files to its disk_quota", func() {
+				application.DiskQuota = "256M"
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", application, config.Instance{Index: 1, HostPort: 8081})
+				Expect(runtimeConfig.DiskLimit).To(Equal(int64(256 * 1024 * 1024)))
+				Expect(runtimeConfig.Mounts[config.TmpVolumeName(runtimeConfig.ContainerName)]).To(Equal("/app/tmp"))
+			})
+
+			It("should reject a memory override that isn't a size", func() {
+				Expect(application.SetMemory("lots")).Should(HaveOccurred())
+				Expect(application.Memory).To(Equal("512M"))
+			})
+
+			It("should give each instance its own container name and publish it on the instance's host port", func() {
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", application, config.Instance{Index: 1, HostPort: 49153})
+				Expect(runtimeConfig.ContainerName).To(Equal("cloudrocker-runtime-rocker-test-1"))
+				Expect(runtimeConfig.PublishedPorts).To(Equal(map[int]int{8080: 49153}))
+			})
+
+			It("should join the shared network and link the application's service containers", func() {
+				application.ServiceLinks = map[string]string{"cloudrocker-service-my-db": "my-db"}
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", application, config.Instance{Index: 0, HostPort: 8080})
+				Expect(runtimeConfig.Network).To(Equal("cloudrocker"))
+				Expect(runtimeConfig.Links).To(Equal(map[string]string{"cloudrocker-service-my-db": "my-db"}))
+			})
+
+			It("should not let the manifest override the system env vars", func() {
+				application.Env["PORT"] = "9090"
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/procfiletestdroplet", application, config.Instance{Index: 0, HostPort: 8080})
+				Expect(runtimeConfig.EnvVars["PORT"]).To(Equal("8080"))
+			})
+		})
+
+		Context("with a Procfile with several process types", func() {
+			It("should run the web process as usual", func() {
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/multiprocessdroplet", config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080, Process: "web"})
+				Expect(runtimeConfig.ContainerName).To(Equal("cloudrocker-runtime-app"))
+				Expect(runtimeConfig.PublishedPorts).To(Equal(map[int]int{8080: 8080}))
+				Expect(runtimeConfig.Command[3:]).To(Equal([]string{"bundle", "exec", "rackup", "config.ru", "-p", "$PORT"}))
+			})
+
+			It("should run another process type in its own, unpublished container", func() {
+				application := config.NewApplication("/test/app")
+				application.Command = "ignored for workers"
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/multiprocessdroplet", application, config.Instance{Index: 0, Process: "worker"})
+				Expect(runtimeConfig.ContainerName).To(Equal("cloudrocker-runtime-app-worker"))
+				Expect(runtimeConfig.PublishedPorts).To(BeEmpty())
+				Expect(runtimeConfig.Mounts["fixtures/multiprocessdroplet/app"]).To(Equal("/app"))
+				Expect(runtimeConfig.Command[3:]).To(Equal([]string{"bundle", "exec", "sidekiq", "-q", "default:", "-c", "5"}))
+				Expect(runtimeConfig.EnvVars["CF_INSTANCE_INDEX"]).To(Equal("0"))
+				Expect(runtimeConfig.EnvVars).NotTo(HaveKey("CF_INSTANCE_PORT"))
+			})
+		})
+
 		Context("with a destination image tag", func() {
 			It("should return a valid ContainerConfig with the correct runtime information", func() {
-				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/testdroplet", "destination/image:tag")
+				runtimeConfig := config.NewRuntimeContainerConfig("fixtures/testdroplet", config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080}, "destination/image:tag")
 				Expect(runtimeConfig.DstImageTag).To(Equal("destination/image:tag"))
 			})
 		})
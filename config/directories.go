@@ -14,15 +14,22 @@ type Directory struct {
 	ContainerDirectory string
 }
 
-func NewDirectories(cloudRockerHomeDir string) *Directories {
+// Staging, droplet and tmp (including the buildpack cache) directories are kept per application,
+// so that several applications can be rocked side by side
+func NewDirectories(cloudRockerHomeDir string, appName string) *Directories {
+	appHomeDir := cloudRockerHomeDir + "/apps/" + appName
 	directories := &Directories{
 		mounts: map[string]Directory{
 			"home":       Directory{cloudRockerHomeDir, ""},
 			"buildpacks": Directory{cloudRockerHomeDir + "/buildpacks", "/cloudrockerbuildpacks"},
 			"rocker":     Directory{cloudRockerHomeDir + "/rocker", "/rocker"},
-			"staging":    Directory{cloudRockerHomeDir + "/staging", "/tmp/app"},
-			"tmp":        Directory{cloudRockerHomeDir + "/tmp", "/tmp"},
-			"droplet":    Directory{cloudRockerHomeDir + "/droplet", ""},
+			"services":   Directory{cloudRockerHomeDir + "/services", ""},
+			"brokers":    Directory{cloudRockerHomeDir + "/brokers", ""},
+			"appHome":    Directory{appHomeDir, ""},
+			"staging":    Directory{appHomeDir + "/staging", "/tmp/app"},
+			"tmp":        Directory{appHomeDir + "/tmp", "/tmp"},
+			"deps":       Directory{appHomeDir + "/deps", "/app/" + DepsDir},
+			"droplet":    Directory{appHomeDir + "/droplet", ""},
 			"baseConfig": Directory{cloudRockerHomeDir + "/baseConfig", ""},
 		},
 		app: utils.Pwd(),
@@ -46,10 +53,32 @@ func (directories *Directories) Rocker() string {
 	return directories.mounts["rocker"].HostDirectory
 }
 
+func (directories *Directories) Services() string {
+	return directories.mounts["services"].HostDirectory
+}
+
+func (directories *Directories) Brokers() string {
+	return directories.mounts["brokers"].HostDirectory
+}
+
 func (directories *Directories) Staging() string {
 	return directories.mounts["staging"].HostDirectory
 }
 
+// Deps is where supply buildpacks install dependencies while staging. It is mounted where the droplet's deps are
+// at runtime, so paths the buildpacks write into scripts and config still resolve when the application runs
+func (directories *Directories) Deps() string {
+	return directories.mounts["deps"].HostDirectory
+}
+
+func (directories *Directories) ContainerDeps() string {
+	return directories.mounts["deps"].ContainerDirectory
+}
+
+func (directories *Directories) AppHome() string {
+	return directories.mounts["appHome"].HostDirectory
+}
+
 func (directories *Directories) App() string {
 	return directories.app
 }
@@ -58,6 +87,16 @@ func (directories *Directories) Tmp() string {
 	return directories.mounts["tmp"].HostDirectory
 }
 
+// The lifecycle compiles with the build artifact cache in the staging container's /tmp/cache
+func (directories *Directories) BuildArtifactsCache() string {
+	return directories.Tmp() + "/cache"
+}
+
+// The lifecycle leaves a tarball of the build artifact cache as it was after compiling in /tmp/output-cache
+func (directories *Directories) OutputBuildArtifactsCache() string {
+	return directories.Tmp() + "/output-cache"
+}
+
 func (directories *Directories) Droplet() string {
 	return directories.mounts["droplet"].HostDirectory
 }
@@ -91,6 +130,7 @@ func (directories *Directories) HostDirectories() []string {
 func (directories *Directories) HostDirectoriesToClean() []string {
 	dirs := []string{
 		directories.Staging(),
+		directories.Deps(),
 		directories.Droplet(),
 	}
 
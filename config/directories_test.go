@@ -17,7 +17,7 @@ var _ = Describe("Directories", func() {
 
 	BeforeEach(func() {
 		cloudRockerHomeDir = "/path/to"
-		testDirectories = config.NewDirectories(cloudRockerHomeDir)
+		testDirectories = config.NewDirectories(cloudRockerHomeDir, "myapp")
 	})
 
 	Describe("Provide a structure for directories", func() {
@@ -33,20 +33,37 @@ var _ = Describe("Directories", func() {
 			Expect(testDirectories.ContainerBuildpacks()).To(Equal("/cloudrockerbuildpacks"))
 		})
 
+		It("should return the application's own cloudrocker directory", func() {
+			Expect(testDirectories.AppHome()).To(Equal(cloudRockerHomeDir + "/apps/myapp"))
+		})
+
 		It("should return the runtime droplet directory", func() {
-			Expect(testDirectories.Droplet()).To(Equal(cloudRockerHomeDir + "/droplet"))
+			Expect(testDirectories.Droplet()).To(Equal(cloudRockerHomeDir + "/apps/myapp/droplet"))
 		})
 
 		It("should return the rocker directory", func() {
 			Expect(testDirectories.Rocker()).To(Equal(cloudRockerHomeDir + "/rocker"))
 		})
 
+		It("should return the services directory", func() {
+			Expect(testDirectories.Services()).To(Equal(cloudRockerHomeDir + "/services"))
+		})
+
+		It("should return the service brokers directory", func() {
+			Expect(testDirectories.Brokers()).To(Equal(cloudRockerHomeDir + "/brokers"))
+		})
+
 		It("should return the staging directory", func() {
-			Expect(testDirectories.Staging()).To(Equal(cloudRockerHomeDir + "/staging"))
+			Expect(testDirectories.Staging()).To(Equal(cloudRockerHomeDir + "/apps/myapp/staging"))
 		})
 
 		It("should return the host cloudrocker tmp directory", func() {
-			Expect(testDirectories.Tmp()).To(Equal(cloudRockerHomeDir + "/tmp"))
+			Expect(testDirectories.Tmp()).To(Equal(cloudRockerHomeDir + "/apps/myapp/tmp"))
+		})
+
+		It("should return the application's build artifact cache and the lifecycle's output cache", func() {
+			Expect(testDirectories.BuildArtifactsCache()).To(Equal(cloudRockerHomeDir + "/apps/myapp/tmp/cache"))
+			Expect(testDirectories.OutputBuildArtifactsCache()).To(Equal(cloudRockerHomeDir + "/apps/myapp/tmp/output-cache"))
 		})
 
 		It("should return the host directory for holding the base container configuration", func() {
@@ -59,13 +76,24 @@ var _ = Describe("Directories", func() {
 		})
 	})
 
+	Describe("Keeping applications apart", func() {
+		It("should give each application its own staging, droplet and tmp directories", func() {
+			otherDirectories := config.NewDirectories(cloudRockerHomeDir, "otherapp")
+			Expect(otherDirectories.Staging()).NotTo(Equal(testDirectories.Staging()))
+			Expect(otherDirectories.Droplet()).NotTo(Equal(testDirectories.Droplet()))
+			Expect(otherDirectories.Tmp()).NotTo(Equal(testDirectories.Tmp()))
+			Expect(otherDirectories.Buildpacks()).To(Equal(testDirectories.Buildpacks()))
+		})
+	})
+
 	Describe("Providing the directories to be mounted in the container", func() {
 		It("should return a mapping of host to container directories", func() {
 			Expect(testDirectories.Mounts()).To(Equal(map[string]string{ // host dir: container dir
-				"/path/to/tmp":        "/tmp",
-				"/path/to/rocker":     "/rocker",
-				"/path/to/buildpacks": "/cloudrockerbuildpacks",
-				"/path/to/staging":    "/tmp/app",
+				"/path/to/apps/myapp/tmp":     "/tmp",
+				"/path/to/rocker":             "/rocker",
+				"/path/to/buildpacks":         "/cloudrockerbuildpacks",
+				"/path/to/apps/myapp/staging": "/tmp/app",
+				"/path/to/apps/myapp/deps":    "/app/.deps",
 			}))
 		})
 	})
@@ -75,10 +103,14 @@ var _ = Describe("Directories", func() {
 			Expect(testDirectories.HostDirectories()).To(ConsistOf(
 				"/path/to",
 				"/path/to/buildpacks",
-				"/path/to/droplet",
+				"/path/to/apps/myapp",
+				"/path/to/apps/myapp/droplet",
 				"/path/to/rocker",
-				"/path/to/staging",
-				"/path/to/tmp",
+				"/path/to/services",
+				"/path/to/brokers",
+				"/path/to/apps/myapp/staging",
+				"/path/to/apps/myapp/tmp",
+				"/path/to/apps/myapp/deps",
 				"/path/to/baseConfig",
 			))
 		})
@@ -87,8 +119,9 @@ var _ = Describe("Directories", func() {
 	Describe("Providing the directories to be cleaned before staging", func() {
 		It("should return a set of directories to be cleaned", func() {
 			Expect(testDirectories.HostDirectoriesToClean()).To(ConsistOf(
-				"/path/to/droplet",
-				"/path/to/staging",
+				"/path/to/apps/myapp/droplet",
+				"/path/to/apps/myapp/deps",
+				"/path/to/apps/myapp/staging",
 			))
 		})
 	})
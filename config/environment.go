@@ -0,0 +1,67 @@
+package config
+
+import "sort"
+
+// Where an env var's value came from. Later sources take precedence over earlier ones: when staging, the staging
+// env group and the host's proxy settings; then the manifest's env, then user overrides, then, when staging, the
+// application's staging env vars; then the variables Cloud Rocker sets itself - defaults, values derived from CLI
+// flags, and bound services or vcap_services.json - which can't be overridden.
+const (
+	DefaultSource      = "default"
+	StagingGroupSource = "staging env group"
+	ProxySource        = "host proxy"
+	ManifestSource     = "manifest"
+	UserSource         = "user"
+	StagingSource      = "staging"
+	FlagSource         = "flag"
+	BindingsSource     = "bound services"
+	ServicesSource     = "vcap_services.json"
+)
+
+type EnvVar struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+type Environment map[string]EnvVar
+
+// An empty value never replaces a value that is already set
+func (environment Environment) Set(name string, value string, source string) {
+	if _, present := environment[name]; present && value == "" {
+		return
+	}
+	environment[name] = EnvVar{Name: name, Value: value, Source: source}
+}
+
+func (environment Environment) SetAll(envVars map[string]string, source string) {
+	for name, value := range envVars {
+		environment.Set(name, value, source)
+	}
+}
+
+func (environment Environment) EnvVars() map[string]string {
+	envVars := make(map[string]string)
+	for name, envVar := range environment {
+		envVars[name] = envVar.Value
+	}
+	return envVars
+}
+
+// List is sorted by name and, as with the containers, leaves out empty values
+func (environment Environment) List() []EnvVar {
+	list := []EnvVar{}
+	for _, envVar := range environment {
+		if envVar.Value != "" {
+			list = append(list, envVar)
+		}
+	}
+	sort.Sort(byName(list))
+	return list
+}
+
+type byName []EnvVar
+
+func (slice byName) Len() int           { return len(slice) }
+func (slice byName) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+func (slice byName) Less(i, j int) bool { return slice[i].Name < slice[j].Name }
@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment", func() {
+	var application *config.Application
+
+	BeforeEach(func() {
+		manifest, _ := config.ParseManifest("fixtures/manifestapp")
+		application = manifest.Application("fixtures/manifestapp")
+	})
+
+	Describe("Setting env vars", func() {
+		It("should let later values win, unless they are empty", func() {
+			environment := config.Environment{}
+			environment.Set("RACK_ENV", "development", config.ManifestSource)
+			environment.Set("RACK_ENV", "production", config.UserSource)
+			environment.Set("RACK_ENV", "", config.FlagSource)
+			Expect(environment["RACK_ENV"]).To(Equal(config.EnvVar{Name: "RACK_ENV", Value: "production", Source: "user"}))
+		})
+
+		It("should list the env vars with values, sorted by name", func() {
+			environment := config.Environment{}
+			environment.Set("B", "b", config.DefaultSource)
+			environment.Set("C", "", config.DefaultSource)
+			environment.Set("A", "a", config.ManifestSource)
+			Expect(environment.List()).To(Equal([]config.EnvVar{
+				{Name: "A", Value: "a", Source: "manifest"},
+				{Name: "B", Value: "b", Source: "default"},
+			}))
+			Expect(environment.EnvVars()).To(Equal(map[string]string{"A": "a", "B": "b", "C": ""}))
+		})
+	})
+
+	Describe("Generating the staging environment", func() {
+		It("should say where each value came from", func() {
+			environment := config.StagingEnvironment(application)
+			Expect(environment["RACK_ENV"].Source).To(Equal("manifest"))
+			Expect(environment["MEMORY_LIMIT"]).To(Equal(config.EnvVar{Name: "MEMORY_LIMIT", Value: "512m", Source: "manifest"}))
+			Expect(environment["CF_STACK"].Source).To(Equal("default"))
+		})
+	})
+
+	Describe("Generating the runtime environment", func() {
+		It("should say where each value came from", func() {
+			application.SetMemory("256M")
+			environment := config.RuntimeEnvironment("fixtures/testdroplet", application, config.Instance{Index: 0, HostPort: 9000, HostPortSource: config.FlagSource})
+			Expect(environment["TIMEOUT"].Source).To(Equal("manifest"))
+			Expect(environment["MEMORY_LIMIT"]).To(Equal(config.EnvVar{Name: "MEMORY_LIMIT", Value: "256m", Source: "flag"}))
+			Expect(environment["CF_INSTANCE_PORT"]).To(Equal(config.EnvVar{Name: "CF_INSTANCE_PORT", Value: "9000", Source: "flag"}))
+			Expect(environment["PORT"]).To(Equal(config.EnvVar{Name: "PORT", Value: "8080", Source: "default"}))
+			Expect(environment["DATABASE_URL"].Source).To(Equal("vcap_services.json"))
+			Expect(environment["VCAP_SERVICES"].Source).To(Equal("vcap_services.json"))
+		})
+
+		It("should not let the manifest override the variables Cloud Rocker sets", func() {
+			application.Env["PORT"] = "9090"
+			environment := config.RuntimeEnvironment("fixtures/testdroplet", application, config.Instance{Index: 0, HostPort: 8080})
+			Expect(environment["PORT"]).To(Equal(config.EnvVar{Name: "PORT", Value: "8080", Source: "default"}))
+			Expect(environment["CF_INSTANCE_PORT"].Source).To(Equal("default"))
+		})
+	})
+})
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/pivotal-golang/bytefmt"
+	"github.com/cloudcredo/cloudrocker/service"
+)
+
+type Manifest struct {
+	Applications []*Application `yaml:"applications"`
+}
+
+type Application struct {
+	Name       string            `yaml:"name"`
+	Memory     string            `yaml:"memory"`
+	DiskQuota  string            `yaml:"disk_quota"`
+	Instances  int               `yaml:"instances"`
+	Path       string            `yaml:"path"`
+	Buildpack  string            `yaml:"buildpack"`
+	Buildpacks []string          `yaml:"buildpacks"`
+	Command    string            `yaml:"command"`
+	Env        map[string]string `yaml:"env"`
+	UserEnv    map[string]string `yaml:"-"`
+
+	StagingEnv      map[string]string `yaml:"-"`
+	StagingEnvGroup map[string]string `yaml:"-"`
+	ProxyEnv        map[string]string `yaml:"-"`
+
+	ServiceBindings []service.Binding `yaml:"-"`
+	ServiceLinks    map[string]string `yaml:"-"`
+
+	memorySource string
+}
+
+func NewApplication(appDir string) *Application {
+	return &Application{
+		Name:      filepath.Base(appDir),
+		Instances: 1,
+		Path:      appDir,
+		Env:       map[string]string{},
+		UserEnv:   map[string]string{},
+
+		StagingEnv:      map[string]string{},
+		StagingEnvGroup: map[string]string{},
+		ProxyEnv:        map[string]string{},
+	}
+}
+
+var unsafeNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// Slug is the application name made safe for use in container and directory names
+func (application *Application) Slug() string {
+	return unsafeNameCharacters.ReplaceAllString(application.Name, "-")
+}
+
+func (application *Application) StagingContainerName() string {
+	return "cloudrocker-staging-" + application.Slug()
+}
+
+func (application *Application) RuntimeContainerName(instanceIndex int) string {
+	return application.ProcessContainerName(WebProcess, instanceIndex)
+}
+
+// Web instances keep the plain runtime container name, other process types add their own name to it
+func (application *Application) ProcessContainerName(process string, instanceIndex int) string {
+	name := "cloudrocker-runtime-" + application.Slug()
+	if process != "" && process != WebProcess {
+		name = name + "-" + process
+	}
+	if instanceIndex > 0 {
+		name = name + "-" + strconv.Itoa(instanceIndex)
+	}
+	return name
+}
+
+// ChosenBuildpacks are the buildpacks to stage with instead of detecting one. The buildpacks list wins over a
+// single buildpack, and more than one means a multi-buildpack staging
+func (application *Application) ChosenBuildpacks() []string {
+	if len(application.Buildpacks) > 0 {
+		return application.Buildpacks
+	}
+	if application.Buildpack != "" {
+		return []string{application.Buildpack}
+	}
+	return nil
+}
+
+// A missing manifest.yml is not an error - the defaults are used instead
+func ParseManifest(appDir string) (*Manifest, error) {
+	manifest := new(Manifest)
+	manifestBytes, err := ioutil.ReadFile(appDir + "/manifest.yml")
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := candiedyaml.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, fmt.Errorf("Failed to parse manifest.yml: %s", err)
+	}
+	for _, application := range manifest.Applications {
+		if err := validateMemory(application.Memory); err != nil {
+			return nil, fmt.Errorf("Invalid memory in manifest.yml: %s", err)
+		}
+		if application.DiskQuota != "" {
+			if _, err := bytefmt.ToMegabytes(application.DiskQuota); err != nil {
+				return nil, fmt.Errorf("Invalid disk_quota in manifest.yml: %s", err)
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// SetMemory overrides the manifest's memory, leaving it alone when memory is empty
+func (application *Application) SetMemory(memory string) error {
+	if memory == "" {
+		return nil
+	}
+	if err := validateMemory(memory); err != nil {
+		return fmt.Errorf("Invalid memory %s: %s", memory, err)
+	}
+	application.Memory = memory
+	application.memorySource = FlagSource
+	return nil
+}
+
+// MemorySource says whether the memory limit is the default, or came from the manifest or a CLI flag
+func (application *Application) MemorySource() string {
+	if application.memorySource == "" {
+		return DefaultSource
+	}
+	return application.memorySource
+}
+
+func validateMemory(memory string) error {
+	if memory == "" {
+		return nil
+	}
+	_, err := bytefmt.ToMegabytes(memory)
+	return err
+}
+
+// Only the first application in a manifest is rocked, with relative paths taken from the manifest's directory
+func (manifest *Manifest) Application(appDir string) *Application {
+	application := NewApplication(appDir)
+	if len(manifest.Applications) == 0 {
+		return application
+	}
+
+	manifestApplication := manifest.Applications[0]
+	if manifestApplication.Name != "" {
+		application.Name = manifestApplication.Name
+	}
+	if manifestApplication.Instances > 0 {
+		application.Instances = manifestApplication.Instances
+	}
+	if manifestApplication.Path != "" {
+		application.Path = manifestApplication.Path
+		if !filepath.IsAbs(application.Path) {
+			application.Path = filepath.Join(appDir, application.Path)
+		}
+	}
+	if manifestApplication.Env != nil {
+		application.Env = manifestApplication.Env
+	}
+	application.Memory = manifestApplication.Memory
+	if application.Memory != "" {
+		application.memorySource = ManifestSource
+	}
+	application.DiskQuota = manifestApplication.DiskQuota
+	application.Buildpack = manifestApplication.Buildpack
+	application.Buildpacks = manifestApplication.Buildpacks
+	application.Command = manifestApplication.Command
+	return application
+}
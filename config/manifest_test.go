@@ -0,0 +1,82 @@
+package config_test
+
+import (
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("Manifest", func() {
+	Describe("Parsing an application's manifest.yml", func() {
+		Context("with a valid manifest.yml", func() {
+			It("should return the application settings from the manifest", func() {
+				manifest, err := config.ParseManifest("fixtures/manifestapp")
+				Expect(err).ShouldNot(HaveOccurred())
+				application := manifest.Application("fixtures/manifestapp")
+				Expect(application.Name).To(Equal("rocker-test"))
+				Expect(application.Memory).To(Equal("512M"))
+				Expect(application.Instances).To(Equal(2))
+				Expect(application.Path).To(Equal("fixtures/manifestapp/build"))
+				Expect(application.Buildpack).To(Equal("https://github.com/cloudfoundry/ruby-buildpack"))
+				Expect(application.Command).To(Equal("bundle exec rackup config.ru -p $PORT"))
+				Expect(application.Env).To(Equal(map[string]string{
+					"RACK_ENV": "development",
+					"TIMEOUT":  "30",
+				}))
+			})
+		})
+
+		Context("with a buildpacks list", func() {
+			It("should choose every buildpack in the list over the single buildpack", func() {
+				manifest, err := config.ParseManifest("fixtures/multibuildpackapp")
+				Expect(err).ShouldNot(HaveOccurred())
+				application := manifest.Application("fixtures/multibuildpackapp")
+				Expect(application.Buildpacks).To(Equal([]string{"binary_buildpack", "python_buildpack"}))
+				Expect(application.ChosenBuildpacks()).To(Equal([]string{"binary_buildpack", "python_buildpack"}))
+			})
+
+			It("should choose the single buildpack, or nothing, without a list", func() {
+				application := config.NewApplication("/path/to/myapp")
+				Expect(application.ChosenBuildpacks()).To(BeEmpty())
+				application.Buildpack = "ruby_buildpack"
+				Expect(application.ChosenBuildpacks()).To(Equal([]string{"ruby_buildpack"}))
+			})
+		})
+
+		Context("without a manifest.yml", func() {
+			It("should return the default application settings", func() {
+				manifest, err := config.ParseManifest("fixtures/testdroplet")
+				Expect(err).ShouldNot(HaveOccurred())
+				application := manifest.Application("/path/to/myapp")
+				Expect(application.Name).To(Equal("myapp"))
+				Expect(application.Memory).To(Equal(""))
+				Expect(application.Instances).To(Equal(1))
+				Expect(application.Path).To(Equal("/path/to/myapp"))
+				Expect(application.Buildpack).To(Equal(""))
+				Expect(application.Command).To(Equal(""))
+				Expect(application.Env).To(BeEmpty())
+			})
+		})
+
+		Context("with an application name that isn't safe for containers", func() {
+			It("should name the containers after a safe version of the application name", func() {
+				application := config.NewApplication("/path/to/my app!")
+				Expect(application.Slug()).To(Equal("my-app-"))
+				Expect(application.StagingContainerName()).To(Equal("cloudrocker-staging-my-app-"))
+				Expect(application.RuntimeContainerName(0)).To(Equal("cloudrocker-runtime-my-app-"))
+				Expect(application.RuntimeContainerName(2)).To(Equal("cloudrocker-runtime-my-app--2"))
+				Expect(application.ProcessContainerName("web", 1)).To(Equal("cloudrocker-runtime-my-app--1"))
+				Expect(application.ProcessContainerName("worker", 0)).To(Equal("cloudrocker-runtime-my-app--worker"))
+				Expect(application.ProcessContainerName("worker", 1)).To(Equal("cloudrocker-runtime-my-app--worker-1"))
+			})
+		})
+
+		Context("with an invalid memory value", func() {
+			It("should return an error", func() {
+				_, err := config.ParseManifest("fixtures/badmanifestapp")
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+	})
+})
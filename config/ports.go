@@ -0,0 +1,36 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+const DefaultHostPort = 8080
+
+// The host ports last used by each instance of an application are kept in its own directory
+func ReadHostPorts(appHomeDir string) ([]int, error) {
+	ports := []int{}
+	portsBytes, err := ioutil.ReadFile(appHomeDir + "/ports.json")
+	if os.IsNotExist(err) {
+		return ports, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(portsBytes, &ports); err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+func WriteHostPorts(appHomeDir string, ports []int) error {
+	if err := os.MkdirAll(appHomeDir, 0755); err != nil {
+		return err
+	}
+	portsBytes, err := json.Marshal(ports)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appHomeDir+"/ports.json", portsBytes, 0644)
+}
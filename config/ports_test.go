@@ -0,0 +1,43 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("Ports", func() {
+	var appHomeDir string
+
+	BeforeEach(func() {
+		appHomeDir, _ = ioutil.TempDir(os.TempDir(), "config-test-ports")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(appHomeDir)
+	})
+
+	Describe("Recording an application's host ports", func() {
+		Context("without recorded ports", func() {
+			It("should return no ports", func() {
+				ports, err := config.ReadHostPorts(appHomeDir)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ports).To(BeEmpty())
+			})
+		})
+
+		Context("with recorded ports", func() {
+			It("should return the recorded ports", func() {
+				err := config.WriteHostPorts(appHomeDir+"/myapp", []int{8081, 49153})
+				Expect(err).ShouldNot(HaveOccurred())
+				ports, err := config.ReadHostPorts(appHomeDir + "/myapp")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ports).To(Equal([]int{8081, 49153}))
+			})
+		})
+	})
+})
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+)
+
+const WebProcess = "web"
+
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*:\s*(.+?)\s*$`)
+
+// Procfiles are read line by line, as Foreman does, because commands are often not valid YAML.
+// Blank lines, comments and lines that don't look like "type: command" are skipped.
+func ParseProcfile(procfilePath string) (map[string]string, error) {
+	procfile, err := os.Open(procfilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer procfile.Close()
+	return parseProcfile(procfile)
+}
+
+func parseProcfile(procfile io.Reader) (map[string]string, error) {
+	processes := map[string]string{}
+	scanner := bufio.NewScanner(procfile)
+	for scanner.Scan() {
+		if match := procfileLine.FindStringSubmatch(scanner.Text()); match != nil {
+			processes[match[1]] = match[2]
+		}
+	}
+	return processes, scanner.Err()
+}
+
+// ProcessTypes lists the process types in a droplet's Procfile, if it has one
+func ProcessTypes(dropletDir string) []string {
+	processes, err := ParseProcfile(dropletDir + "/app/Procfile")
+	if err != nil {
+		return []string{}
+	}
+	return sortedKeys(processes)
+}
+
+func sortedKeys(processes map[string]string) []string {
+	keys := []string{}
+	for key := range processes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
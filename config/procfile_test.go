@@ -0,0 +1,37 @@
+package config_test
+
+import (
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("Procfile", func() {
+	Describe("Parsing a Procfile", func() {
+		It("should return every process type, even when the commands aren't valid YAML", func() {
+			processes, err := config.ParseProcfile("fixtures/multiprocessdroplet/app/Procfile")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(processes).To(Equal(map[string]string{
+				"web":    "bundle exec rackup config.ru -p $PORT",
+				"worker": "bundle exec sidekiq -q default: -c 5",
+				"clock":  `bundle exec clockwork "clock.rb"`,
+			}))
+		})
+
+		It("should return an error when there is no Procfile", func() {
+			_, err := config.ParseProcfile("fixtures/testdroplet/app/Procfile")
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Listing a droplet's process types", func() {
+		It("should return the sorted process types", func() {
+			Expect(config.ProcessTypes("fixtures/multiprocessdroplet")).To(Equal([]string{"clock", "web", "worker"}))
+		})
+
+		It("should return no process types for a droplet without a Procfile", func() {
+			Expect(config.ProcessTypes("fixtures/testdroplet")).To(BeEmpty())
+		})
+	})
+})
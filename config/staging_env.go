@@ -0,0 +1,55 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Staging env vars are only given to the staging container - buildpacks see them, but the droplet, the runtime
+// container and built images don't, so they can hold the credentials of private package registries.
+// The application's own are kept in its directory; the group, like Cloud Foundry's staging environment variable
+// group, applies to every application
+func ReadStagingEnv(appHomeDir string) (map[string]string, error) {
+	return readEnvFile(appHomeDir + "/staging_env.json")
+}
+
+func WriteStagingEnv(appHomeDir string, stagingEnv map[string]string) error {
+	return writeEnvFile(appHomeDir+"/staging_env.json", stagingEnv)
+}
+
+func ReadStagingEnvGroup(cloudRockerHomeDir string) (map[string]string, error) {
+	return readEnvFile(cloudRockerHomeDir + "/staging_env_group.json")
+}
+
+func WriteStagingEnvGroup(cloudRockerHomeDir string, stagingEnvGroup map[string]string) error {
+	return writeEnvFile(cloudRockerHomeDir+"/staging_env_group.json", stagingEnvGroup)
+}
+
+// ParseStagingEnvGroup reads a group given as a JSON object of names and values, as cf
+// set-staging-environment-variable-group takes it
+func ParseStagingEnvGroup(groupJSON string) (map[string]string, error) {
+	stagingEnvGroup := map[string]string{}
+	if err := json.Unmarshal([]byte(groupJSON), &stagingEnvGroup); err != nil {
+		return nil, fmt.Errorf("Invalid staging env group - use a JSON object of names and string values, e.g. '{\"NAME\":\"value\"}': %s", err)
+	}
+	for name := range stagingEnvGroup {
+		if err := ValidateEnvVarName(name); err != nil {
+			return nil, err
+		}
+	}
+	return stagingEnvGroup, nil
+}
+
+var proxyEnvVarNames = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"}
+
+// HostProxyEnv is the proxy configuration of the host, for buildpacks that download through a proxy
+func HostProxyEnv() map[string]string {
+	proxyEnv := map[string]string{}
+	for _, name := range proxyEnvVarNames {
+		if value := os.Getenv(name); value != "" {
+			proxyEnv[name] = value
+		}
+	}
+	return proxyEnv
+}
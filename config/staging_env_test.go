@@ -0,0 +1,69 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingEnv", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		tmpDir, _ = ioutil.TempDir(os.TempDir(), "config-test-staging-env")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should keep an application's staging env vars where only the user can read them", func() {
+		err := config.WriteStagingEnv(tmpDir+"/app", map[string]string{"BUNDLE_GEMS__EXAMPLE__COM": "token"})
+		Expect(err).ShouldNot(HaveOccurred())
+		stagingEnv, err := config.ReadStagingEnv(tmpDir + "/app")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stagingEnv).To(Equal(map[string]string{"BUNDLE_GEMS__EXAMPLE__COM": "token"}))
+		info, _ := os.Stat(tmpDir + "/app/staging_env.json")
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("should keep the staging env group for every application", func() {
+		stagingEnvGroup, err := config.ReadStagingEnvGroup(tmpDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stagingEnvGroup).To(BeEmpty())
+		err = config.WriteStagingEnvGroup(tmpDir, map[string]string{"NPM_CONFIG_REGISTRY": "https://npm.example.com"})
+		Expect(err).ShouldNot(HaveOccurred())
+		stagingEnvGroup, err = config.ReadStagingEnvGroup(tmpDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(stagingEnvGroup).To(Equal(map[string]string{"NPM_CONFIG_REGISTRY": "https://npm.example.com"}))
+	})
+
+	Describe("Parsing a staging env group", func() {
+		It("should take a JSON object of names and values", func() {
+			stagingEnvGroup, err := config.ParseStagingEnvGroup(`{"NPM_CONFIG_REGISTRY":"https://npm.example.com"}`)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(stagingEnvGroup).To(Equal(map[string]string{"NPM_CONFIG_REGISTRY": "https://npm.example.com"}))
+		})
+
+		It("should reject anything else", func() {
+			_, err := config.ParseStagingEnvGroup(`NPM_CONFIG_REGISTRY=https://npm.example.com`)
+			Expect(err).Should(HaveOccurred())
+			_, err = config.ParseStagingEnvGroup(`{"NPM-REGISTRY":"https://npm.example.com"}`)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	It("should take the proxy settings from the host", func() {
+		os.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+		os.Setenv("no_proxy", "localhost")
+		defer os.Unsetenv("HTTPS_PROXY")
+		defer os.Unsetenv("no_proxy")
+		proxyEnv := config.HostProxyEnv()
+		Expect(proxyEnv).To(HaveKeyWithValue("HTTPS_PROXY", "http://proxy.example.com:3128"))
+		Expect(proxyEnv).To(HaveKeyWithValue("no_proxy", "localhost"))
+	})
+})
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+// StagingSummary is what a staging produced, kept with the application so it can be shown again later
+type StagingSummary struct {
+	BuildpackKey      string   `json:"buildpack_key"`
+	BuildpackVersion  string   `json:"buildpack_version,omitempty"`
+	DetectedBuildpack string   `json:"detected_buildpack"`
+	ProcessTypes      []string `json:"process_types"`
+	StartCommand      string   `json:"start_command"`
+	DropletSize       int64    `json:"droplet_size"`
+}
+
+// NewStagingSummary summarises a staging from its result.json and the staging_info.yml and Procfile in its droplet.
+// Imported droplets have no result.json, so their summary comes from the droplet alone
+func NewStagingSummary(dropletPath string, resultPath string) (*StagingSummary, error) {
+	summary := new(StagingSummary)
+	dropletInfo, err := os.Stat(dropletPath)
+	if err != nil {
+		return nil, err
+	}
+	summary.DropletSize = dropletInfo.Size()
+
+	resultBytes, err := ioutil.ReadFile(resultPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var stagingResult buildpack_app_lifecycle.StagingResult
+		if err := json.Unmarshal(resultBytes, &stagingResult); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %s", resultPath, err)
+		}
+		summary.BuildpackKey = stagingResult.BuildpackKey
+		summary.DetectedBuildpack = stagingResult.DetectedBuildpack
+		if summary.StartCommand, err = ReadDetectedStartCommand(resultPath); err != nil {
+			return nil, err
+		}
+	}
+
+	stagingInfo, procfile, err := readDropletMetadata(dropletPath)
+	if err != nil {
+		return nil, err
+	}
+	if summary.DetectedBuildpack == "" {
+		summary.DetectedBuildpack = stagingInfo.DetectedBuildpack
+	}
+	if summary.StartCommand == "" {
+		summary.StartCommand = stagingInfo.StartCommand
+	}
+	if summary.StartCommand != "" {
+		procfile[WebProcess] = summary.StartCommand
+	}
+	summary.ProcessTypes = sortedKeys(procfile)
+	return summary, nil
+}
+
+// The droplet is a gzipped tarball, which is read through once for its staging_info.yml and Procfile
+func readDropletMetadata(dropletPath string) (*StagingInfoYml, map[string]string, error) {
+	stagingInfo := new(StagingInfoYml)
+	procfile := map[string]string{}
+
+	dropletFile, err := os.Open(dropletPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dropletFile.Close()
+	gzipReader, err := gzip.NewReader(dropletFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read the droplet: %s", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to read the droplet: %s", err)
+		}
+		switch strings.TrimPrefix(path.Clean(header.Name), "./") {
+		case "staging_info.yml":
+			if err := candiedyaml.NewDecoder(tarReader).Decode(stagingInfo); err != nil {
+				return nil, nil, fmt.Errorf("Failed to decode staging_info.yml: %s", err)
+			}
+		case "app/Procfile":
+			if procfile, err = parseProcfile(tarReader); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return stagingInfo, procfile, nil
+}
+
+func WriteStagingSummary(appHomeDir string, summary *StagingSummary) error {
+	if err := os.MkdirAll(appHomeDir, 0755); err != nil {
+		return err
+	}
+	summaryBytes, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(appHomeDir+"/staging.json", summaryBytes, 0644)
+}
+
+// ReadStagingSummary returns nil for an application that hasn't been staged
+func ReadStagingSummary(appHomeDir string) (*StagingSummary, error) {
+	summaryBytes, err := ioutil.ReadFile(appHomeDir + "/staging.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary := new(StagingSummary)
+	if err := json.Unmarshal(summaryBytes, summary); err != nil {
+		return nil, fmt.Errorf("Failed to parse the staging summary: %s", err)
+	}
+	return summary, nil
+}
@@ -0,0 +1,62 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("StagingSummary", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		tmpDir, _ = ioutil.TempDir(os.TempDir(), "config-test-staging-summary")
+		err := exec.Command("tar", "-czf", tmpDir+"/droplet", "-C", "fixtures/stageddroplet", ".").Run()
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should summarise the staging result and the droplet", func() {
+		summary, err := config.NewStagingSummary(tmpDir+"/droplet", "fixtures/stageddroplet/result.json")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(summary.BuildpackKey).To(Equal("ruby-buildpack"))
+		Expect(summary.DetectedBuildpack).To(Equal("Ruby"))
+		Expect(summary.StartCommand).To(Equal("bundle exec puma"))
+		Expect(summary.ProcessTypes).To(Equal([]string{"web", "worker"}))
+		dropletInfo, _ := os.Stat(tmpDir + "/droplet")
+		Expect(summary.DropletSize).To(Equal(dropletInfo.Size()))
+	})
+
+	It("should summarise a droplet without a staging result from its staging_info.yml", func() {
+		summary, err := config.NewStagingSummary(tmpDir+"/droplet", tmpDir+"/result.json")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(summary.BuildpackKey).To(Equal(""))
+		Expect(summary.DetectedBuildpack).To(Equal("Ruby"))
+		Expect(summary.StartCommand).To(Equal("bundle exec puma"))
+	})
+
+	It("should return an error without a droplet", func() {
+		_, err := config.NewStagingSummary(tmpDir+"/missing", "fixtures/stageddroplet/result.json")
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("should keep the summary with the application", func() {
+		summary, err := config.ReadStagingSummary(tmpDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(summary).To(BeNil())
+
+		written := &config.StagingSummary{BuildpackKey: "go-buildpack", ProcessTypes: []string{"web"}, StartCommand: "gocf", DropletSize: 2048}
+		Expect(config.WriteStagingSummary(tmpDir, written)).ShouldNot(HaveOccurred())
+		summary, err = config.ReadStagingSummary(tmpDir)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(summary).To(Equal(written))
+	})
+})
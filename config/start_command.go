@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle/protocol"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+type StagingInfoYml struct {
+	DetectedBuildpack string `yaml:"detected_buildpack"`
+	StartCommand      string `yaml:"start_command"`
+}
+
+// StartCommand picks the command for a process type. For web, the precedence is the command override
+// (rock up -c) or the manifest's command, then the Procfile, then the start command the buildpack detected.
+// Other process types only come from the Procfile.
+func StartCommand(application *Application, process string, procfile map[string]string, detectedStartCommand string) (string, error) {
+	if process != "" && process != WebProcess {
+		if command := procfile[process]; command != "" {
+			return command, nil
+		}
+		return "", fmt.Errorf("There is no %s process in the Procfile - the process types are %s", process, strings.Join(sortedKeys(procfile), ", "))
+	}
+	for _, command := range []string{application.Command, procfile[WebProcess], detectedStartCommand} {
+		if strings.TrimSpace(command) != "" {
+			return command, nil
+		}
+	}
+	return "", fmt.Errorf("No start command found for %s - add a command to manifest.yml, a web process to a Procfile, or use -c", application.Name)
+}
+
+// SplitCommand splits a command into words on unquoted whitespace. Quotes and escapes stay in the words,
+// so that the launcher's eval sees the command just as it was written.
+func SplitCommand(command string) ([]string, error) {
+	words := []string{}
+	var word bytes.Buffer
+	var quote rune
+	inWord, escaped := false, false
+	for _, char := range command {
+		switch {
+		case escaped:
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case unicode.IsSpace(char):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteRune(char)
+		inWord = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated %c quote in command: %s", quote, command)
+	}
+	if escaped {
+		return nil, fmt.Errorf("Unfinished escape at the end of command: %s", command)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// ReadDetectedStartCommand reads the web start command the buildpack detected from a staging result.json
+func ReadDetectedStartCommand(resultPath string) (string, error) {
+	resultBytes, err := ioutil.ReadFile(resultPath)
+	if err != nil {
+		return "", err
+	}
+	var stagingResult buildpack_app_lifecycle.StagingResult
+	if err := json.Unmarshal(resultBytes, &stagingResult); err != nil {
+		return "", fmt.Errorf("Failed to parse %s: %s", resultPath, err)
+	}
+	if command := stagingResult.DetectedStartCommand[WebProcess]; command != "" {
+		return command, nil
+	}
+	var executionMetadata protocol.ExecutionMetadata
+	if stagingResult.ExecutionMetadata != "" {
+		if err := json.Unmarshal([]byte(stagingResult.ExecutionMetadata), &executionMetadata); err != nil {
+			return "", fmt.Errorf("Failed to parse the execution metadata in %s: %s", resultPath, err)
+		}
+	}
+	return executionMetadata.StartCommand, nil
+}
+
+// Droplets staged before result.json was kept with them only have staging_info.yml
+func dropletDetectedStartCommand(dropletDir string) (string, error) {
+	if command, err := ReadDetectedStartCommand(dropletDir + "/result.json"); !os.IsNotExist(err) {
+		return command, err
+	}
+	stagingInfoFile, err := os.Open(dropletDir + "/staging_info.yml")
+	if err != nil {
+		return "", fmt.Errorf("Unable to find staging_info.yml - has the application been staged?")
+	}
+	defer stagingInfoFile.Close()
+	stagingInfo := new(StagingInfoYml)
+	if err := candiedyaml.NewDecoder(stagingInfoFile).Decode(stagingInfo); err != nil {
+		return "", fmt.Errorf("Failed to decode staging_info.yml: %s", err)
+	}
+	return stagingInfo.StartCommand, nil
+}
+
+func dropletStartCommand(dropletDir string, application *Application, process string) ([]string, error) {
+	detectedStartCommand, err := dropletDetectedStartCommand(dropletDir)
+	if err != nil {
+		return nil, err
+	}
+	procfile, _ := ParseProcfile(dropletDir + "/app/Procfile")
+	command, err := StartCommand(application, process, procfile, detectedStartCommand)
+	if err != nil {
+		return nil, err
+	}
+	return SplitCommand(command)
+}
@@ -0,0 +1,100 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("StartCommand", func() {
+	Describe("Splitting a command into words", func() {
+		It("should split on runs of unquoted whitespace", func() {
+			words, err := config.SplitCommand("  bundle exec   rackup\tconfig.ru ")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(words).To(Equal([]string{"bundle", "exec", "rackup", "config.ru"}))
+		})
+
+		It("should keep quoted and escaped whitespace, and the quoting, in the words", func() {
+			words, err := config.SplitCommand(`sh -c 'echo "hi  there"; sleep 1' "$HOME/my app" a\ b -p $PORT`)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(words).To(Equal([]string{"sh", "-c", `'echo "hi  there"; sleep 1'`, `"$HOME/my app"`, `a\ b`, "-p", "$PORT"}))
+		})
+
+		It("should refuse a command with an unterminated quote", func() {
+			_, err := config.SplitCommand(`echo "hello`)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Choosing the start command", func() {
+		var (
+			application *config.Application
+			procfile    map[string]string
+		)
+
+		BeforeEach(func() {
+			application = config.NewApplication("/test/app")
+			procfile = map[string]string{"web": "procfile web", "worker": "procfile worker"}
+		})
+
+		It("should prefer the override or manifest command", func() {
+			application.Command = "manifest command"
+			command, err := config.StartCommand(application, "web", procfile, "detected")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(command).To(Equal("manifest command"))
+		})
+
+		It("should use the Procfile before the buildpack's detected command", func() {
+			command, err := config.StartCommand(application, "web", procfile, "detected")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(command).To(Equal("procfile web"))
+		})
+
+		It("should fall back to the buildpack's detected command", func() {
+			command, err := config.StartCommand(application, "web", map[string]string{}, "detected")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(command).To(Equal("detected"))
+		})
+
+		It("should only take other process types from the Procfile", func() {
+			application.Command = "manifest command"
+			command, err := config.StartCommand(application, "worker", procfile, "detected")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(command).To(Equal("procfile worker"))
+			_, err = config.StartCommand(application, "clock", procfile, "detected")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("should return an error when there is nothing to start", func() {
+			_, err := config.StartCommand(application, "web", nil, "")
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Reading the buildpack's detected start command", func() {
+		It("should read the detected start command from result.json", func() {
+			command, err := config.ReadDetectedStartCommand("fixtures/resultdroplet/result.json")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(command).To(Equal("bundle exec rackup  config.ru -p $PORT -E 'deployment mode'"))
+		})
+
+		It("should fall back to the execution metadata", func() {
+			resultFile, _ := ioutil.TempFile(os.TempDir(), "config-test-result")
+			resultFile.WriteString(`{"execution_metadata":"{\"start_command\":\"bundle exec puma\"}","detected_start_command":{}}`)
+			resultFile.Close()
+			command, err := config.ReadDetectedStartCommand(resultFile.Name())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(command).To(Equal("bundle exec puma"))
+			os.Remove(resultFile.Name())
+		})
+
+		It("should use result.json in preference to staging_info.yml when running a droplet", func() {
+			runtimeConfig := config.NewRuntimeContainerConfig("fixtures/resultdroplet", config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080})
+			Expect(runtimeConfig.Command[3:]).To(Equal([]string{"bundle", "exec", "rackup", "config.ru", "-p", "$PORT", "-E", "'deployment mode'"}))
+		})
+	})
+})
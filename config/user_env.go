@@ -0,0 +1,55 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var envVarName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Env vars set with rock set-env are kept in the application's own directory, like cf set-env keeps them with the app
+func ReadUserEnv(appHomeDir string) (map[string]string, error) {
+	return readEnvFile(appHomeDir + "/env.json")
+}
+
+func WriteUserEnv(appHomeDir string, userEnv map[string]string) error {
+	return writeEnvFile(appHomeDir+"/env.json", userEnv)
+}
+
+func ValidateEnvVarName(name string) error {
+	if !envVarName.MatchString(name) {
+		return fmt.Errorf("Invalid env var name %s - use letters, digits and underscores, not starting with a digit", name)
+	}
+	return nil
+}
+
+func readEnvFile(path string) (map[string]string, error) {
+	env := map[string]string{}
+	envBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return env, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(envBytes, &env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Env vars can hold credentials, so only the user can read them
+func writeEnvFile(path string, env map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	envBytes, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, envBytes, 0600)
+}
@@ -0,0 +1,70 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("UserEnv", func() {
+	var appHomeDir string
+
+	BeforeEach(func() {
+		appHomeDir, _ = ioutil.TempDir(os.TempDir(), "config-test-user-env")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(appHomeDir)
+	})
+
+	Describe("Saving an application's env vars", func() {
+		Context("without saved env vars", func() {
+			It("should return no env vars", func() {
+				userEnv, err := config.ReadUserEnv(appHomeDir)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(userEnv).To(BeEmpty())
+			})
+		})
+
+		Context("with saved env vars", func() {
+			It("should return the saved env vars", func() {
+				err := config.WriteUserEnv(appHomeDir+"/app", map[string]string{"GREETING": `hello "world"`})
+				Expect(err).ShouldNot(HaveOccurred())
+				userEnv, err := config.ReadUserEnv(appHomeDir + "/app")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(userEnv).To(Equal(map[string]string{"GREETING": `hello "world"`}))
+			})
+		})
+	})
+
+	Describe("Validating env var names", func() {
+		It("should accept names a shell could use", func() {
+			Expect(config.ValidateEnvVarName("JAVA_OPTS")).ShouldNot(HaveOccurred())
+			Expect(config.ValidateEnvVarName("_private2")).ShouldNot(HaveOccurred())
+		})
+
+		It("should reject other names", func() {
+			Expect(config.ValidateEnvVarName("2FAST")).Should(HaveOccurred())
+			Expect(config.ValidateEnvVarName("MY-VAR")).Should(HaveOccurred())
+			Expect(config.ValidateEnvVarName("")).Should(HaveOccurred())
+		})
+	})
+
+	Describe("Merging user env vars into the container env", func() {
+		It("should let user env vars override the manifest, but not Cloud Rocker's own variables", func() {
+			manifest, _ := config.ParseManifest("fixtures/manifestapp")
+			application := manifest.Application("fixtures/manifestapp")
+			application.UserEnv = map[string]string{"RACK_ENV": "production", "PORT": "9090", "DEBUG": "true"}
+			runtimeConfig := config.NewRuntimeContainerConfig("fixtures/testdroplet", application, config.Instance{Index: 0, HostPort: 8080})
+			Expect(runtimeConfig.EnvVars["RACK_ENV"]).To(Equal("production"))
+			Expect(runtimeConfig.EnvVars["DEBUG"]).To(Equal("true"))
+			Expect(runtimeConfig.EnvVars["PORT"]).To(Equal("8080"))
+			stageConfig := config.NewStageContainerConfig(config.NewDirectories("/test", "app"), application)
+			Expect(stageConfig.EnvVars["RACK_ENV"]).To(Equal("production"))
+		})
+	})
+})
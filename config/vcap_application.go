@@ -0,0 +1,145 @@
+package config
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/pivotal-golang/bytefmt"
+)
+
+const (
+	DefaultMemory    = "1024M"
+	DefaultDiskQuota = "1024M"
+	instanceIP       = "127.0.0.1"
+	spaceName        = "cloudrocker"
+	containerPort    = 8080
+)
+
+type VcapApplication struct {
+	ApplicationID      string                `json:"application_id"`
+	ApplicationName    string                `json:"application_name"`
+	ApplicationURIs    []string              `json:"application_uris"`
+	ApplicationVersion string                `json:"application_version"`
+	Host               string                `json:"host,omitempty"`
+	InstanceID         string                `json:"instance_id,omitempty"`
+	InstanceIndex      *int                  `json:"instance_index,omitempty"`
+	Limits             VcapApplicationLimits `json:"limits"`
+	Name               string                `json:"name"`
+	Port               int                   `json:"port,omitempty"`
+	SpaceID            string                `json:"space_id"`
+	SpaceName          string                `json:"space_name"`
+	URIs               []string              `json:"uris"`
+	Version            string                `json:"version"`
+}
+
+type VcapApplicationLimits struct {
+	Disk uint64 `json:"disk"`
+	FDs  int    `json:"fds"`
+	Mem  uint64 `json:"mem"`
+}
+
+type instancePort struct {
+	External int `json:"external"`
+	Internal int `json:"internal"`
+}
+
+// IDs are derived from the application name, so they are stable between runs
+func NewVcapApplication(application *Application) *VcapApplication {
+	return &VcapApplication{
+		ApplicationID:      guid(application.Name),
+		ApplicationName:    application.Name,
+		ApplicationURIs:    []string{},
+		ApplicationVersion: guid(application.Name + "/version"),
+		Limits: VcapApplicationLimits{
+			Disk: megabytes(application.DiskQuota, DefaultDiskQuota),
+			FDs:  16384,
+			Mem:  megabytes(application.Memory, DefaultMemory),
+		},
+		Name:      application.Name,
+		SpaceID:   guid(spaceName),
+		SpaceName: spaceName,
+		URIs:      []string{},
+		Version:   guid(application.Name + "/version"),
+	}
+}
+
+func (vcapApplication *VcapApplication) ForInstance(instance Instance) *VcapApplication {
+	instanceVcapApplication := *vcapApplication
+	uris := []string{}
+	if instance.HostPort != 0 {
+		uris = []string{"localhost:" + strconv.Itoa(instance.HostPort)}
+	}
+	instanceIndex := instance.Index
+	instanceVcapApplication.ApplicationURIs = uris
+	instanceVcapApplication.URIs = uris
+	instanceVcapApplication.Host = "0.0.0.0"
+	instanceVcapApplication.Port = containerPort
+	instanceVcapApplication.InstanceID = instanceGUID(vcapApplication.ApplicationID, instance)
+	instanceVcapApplication.InstanceIndex = &instanceIndex
+	return &instanceVcapApplication
+}
+
+func setStagingApplicationEnvVars(environment Environment, application *Application) {
+	vcapApplication := NewVcapApplication(application)
+	environment.Set("VCAP_APPLICATION", toJSON(vcapApplication), DefaultSource)
+	environment.Set("MEMORY_LIMIT", fmt.Sprintf("%dm", vcapApplication.Limits.Mem), application.MemorySource())
+}
+
+func setRuntimeApplicationEnvVars(environment Environment, application *Application, instance Instance) {
+	vcapApplication := NewVcapApplication(application).ForInstance(instance)
+	environment.Set("VCAP_APPLICATION", toJSON(vcapApplication), DefaultSource)
+	environment.Set("VCAP_APP_HOST", vcapApplication.Host, DefaultSource)
+	environment.Set("VCAP_APP_PORT", strconv.Itoa(containerPort), DefaultSource)
+	environment.Set("MEMORY_LIMIT", fmt.Sprintf("%dm", vcapApplication.Limits.Mem), application.MemorySource())
+	environment.Set("CF_INSTANCE_INDEX", strconv.Itoa(instance.Index), DefaultSource)
+	environment.Set("CF_INSTANCE_GUID", vcapApplication.InstanceID, DefaultSource)
+	environment.Set("CF_INSTANCE_IP", instanceIP, DefaultSource)
+	if instance.HostPort == 0 {
+		return
+	}
+	environment.Set("CF_INSTANCE_PORT", strconv.Itoa(instance.HostPort), instance.hostPortSource())
+	environment.Set("CF_INSTANCE_ADDR", instanceIP+":"+strconv.Itoa(instance.HostPort), instance.hostPortSource())
+	environment.Set("CF_INSTANCE_PORTS", toJSON([]instancePort{{External: instance.HostPort, Internal: containerPort}}), instance.hostPortSource())
+}
+
+func (instance Instance) hostPortSource() string {
+	if instance.HostPortSource == "" {
+		return DefaultSource
+	}
+	return instance.HostPortSource
+}
+
+func megabytes(quantity string, defaultQuantity string) uint64 {
+	if quantity == "" {
+		quantity = defaultQuantity
+	}
+	megabytes, err := bytefmt.ToMegabytes(quantity)
+	if err != nil {
+		log.Fatalf("Invalid quantity %s: %s", quantity, err)
+	}
+	return megabytes
+}
+
+func instanceGUID(applicationID string, instance Instance) string {
+	seed := applicationID + "/" + strconv.Itoa(instance.Index) + "/" + strconv.Itoa(instance.HostPort)
+	if instance.Process != "" && instance.Process != WebProcess {
+		seed = seed + "/" + instance.Process
+	}
+	return guid(seed)
+}
+
+func guid(seed string) string {
+	sum := md5.Sum([]byte(seed))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func toJSON(value interface{}) string {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		log.Fatalf("Failed to encode %v: %s", value, err)
+	}
+	return string(jsonBytes)
+}
@@ -0,0 +1,74 @@
+package config_test
+
+import (
+	"encoding/json"
+
+	"github.com/cloudcredo/cloudrocker/config"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("VcapApplication", func() {
+	var application *config.Application
+
+	BeforeEach(func() {
+		manifest, _ := config.ParseManifest("fixtures/manifestapp")
+		application = manifest.Application("fixtures/manifestapp")
+	})
+
+	Describe("Generating the application environment for a runtime instance", func() {
+		var envVars map[string]string
+
+		BeforeEach(func() {
+			envVars = config.NewRuntimeContainerConfig("fixtures/testdroplet", application, config.Instance{Index: 1, HostPort: 49153}).EnvVars
+		})
+
+		It("should describe the instance in the CF_INSTANCE env vars", func() {
+			Expect(envVars["CF_INSTANCE_INDEX"]).To(Equal("1"))
+			Expect(envVars["CF_INSTANCE_IP"]).To(Equal("127.0.0.1"))
+			Expect(envVars["CF_INSTANCE_PORT"]).To(Equal("49153"))
+			Expect(envVars["CF_INSTANCE_ADDR"]).To(Equal("127.0.0.1:49153"))
+			Expect(envVars["CF_INSTANCE_PORTS"]).To(Equal(`[{"external":49153,"internal":8080}]`))
+			Expect(envVars["CF_INSTANCE_GUID"]).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`))
+			Expect(envVars["VCAP_APP_HOST"]).To(Equal("0.0.0.0"))
+			Expect(envVars["VCAP_APP_PORT"]).To(Equal("8080"))
+			Expect(envVars["MEMORY_LIMIT"]).To(Equal("512m"))
+		})
+
+		It("should generate a VCAP_APPLICATION for the instance", func() {
+			var vcapApplication config.VcapApplication
+			err := json.Unmarshal([]byte(envVars["VCAP_APPLICATION"]), &vcapApplication)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(vcapApplication.Name).To(Equal("rocker-test"))
+			Expect(vcapApplication.ApplicationName).To(Equal("rocker-test"))
+			Expect(vcapApplication.URIs).To(Equal([]string{"localhost:49153"}))
+			Expect(vcapApplication.ApplicationURIs).To(Equal([]string{"localhost:49153"}))
+			Expect(*vcapApplication.InstanceIndex).To(Equal(1))
+			Expect(vcapApplication.InstanceID).To(Equal(envVars["CF_INSTANCE_GUID"]))
+			Expect(vcapApplication.Port).To(Equal(8080))
+			Expect(vcapApplication.Limits).To(Equal(config.VcapApplicationLimits{Disk: 1024, FDs: 16384, Mem: 512}))
+			Expect(vcapApplication.SpaceName).To(Equal("cloudrocker"))
+		})
+	})
+
+	Describe("Generating the application environment for staging", func() {
+		It("should generate a VCAP_APPLICATION without instance details", func() {
+			envVars := config.NewStageContainerConfig(config.NewDirectories("/test", "app"), application).EnvVars
+			var vcapApplication map[string]interface{}
+			err := json.Unmarshal([]byte(envVars["VCAP_APPLICATION"]), &vcapApplication)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(vcapApplication["name"]).To(Equal("rocker-test"))
+			Expect(vcapApplication).NotTo(HaveKey("instance_index"))
+			Expect(vcapApplication).NotTo(HaveKey("instance_id"))
+			Expect(envVars).NotTo(HaveKey("CF_INSTANCE_INDEX"))
+		})
+	})
+
+	Describe("Identifying an application", func() {
+		It("should give the same application the same IDs on every run", func() {
+			Expect(config.NewVcapApplication(application).ApplicationID).To(Equal(config.NewVcapApplication(application).ApplicationID))
+			Expect(config.NewVcapApplication(application).ApplicationID).NotTo(Equal(config.NewVcapApplication(config.NewApplication("/other")).ApplicationID))
+		})
+	})
+})
@@ -21,7 +21,12 @@ type DockerClient interface {
 	CreateContainer(docker.CreateContainerOptions) (*docker.Container, error)
 	StartContainer(string, *docker.HostConfig) error
 	AttachToContainerNonBlocking(docker.AttachToContainerOptions) (docker.CloseWaiter, error)
-	AddEventListener(chan<- *docker.APIEvents) error
+	WaitContainer(string) (int, error)
+	InspectContainer(string) (*docker.Container, error)
+	NetworkInfo(string) (*docker.Network, error)
+	CreateNetwork(docker.CreateNetworkOptions) (*docker.Network, error)
+	CreateVolume(docker.CreateVolumeOptions) (*docker.Volume, error)
+	RemoveVolume(string) error
 }
 
 func GetNewClient() (client *docker.Client) {
@@ -114,6 +119,97 @@ func GetContainerID(client DockerClient, containerName string) (containerID stri
 	return ""
 }
 
+func ContainerOOMKilled(client DockerClient, containerName string) bool {
+	containerID := GetContainerID(client, containerName)
+	if containerID == "" {
+		return false
+	}
+	container, err := client.InspectContainer(containerID)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	return container.State.OOMKilled
+}
+
+// ContainerState describes a container as running, or how it exited - empty when there is no such container
+func ContainerState(client DockerClient, containerName string) string {
+	containerID := GetContainerID(client, containerName)
+	if containerID == "" {
+		return ""
+	}
+	container, err := client.InspectContainer(containerID)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	switch {
+	case container.State.Running:
+		return "running"
+	case container.State.OOMKilled:
+		return "killed for running out of memory - raise its memory limit with --memory or in manifest.yml"
+	}
+	return fmt.Sprintf("exited with status %d", container.State.ExitCode)
+}
+
+func ContainerRunning(client DockerClient, containerName string) bool {
+	containerID := GetContainerID(client, containerName)
+	if containerID == "" {
+		return false
+	}
+	container, err := client.InspectContainer(containerID)
+	if err != nil {
+		log.Fatalf("Error: %s", err)
+	}
+	return container.State.Running
+}
+
+// EnsureNetwork creates the user-defined network the first time a container needs it. Another rock creating it at
+// the same time is not an error
+func EnsureNetwork(client DockerClient, writer io.Writer, name string) error {
+	_, err := client.NetworkInfo(name)
+	if err == nil {
+		return nil
+	}
+	if _, noSuchNetwork := err.(*docker.NoSuchNetwork); !noSuchNetwork {
+		return err
+	}
+	fmt.Fprintf(writer, "Creating the %s Docker network...\n", name)
+	_, err = client.CreateNetwork(docker.CreateNetworkOptions{
+		Name:           name,
+		Driver:         "bridge",
+		CheckDuplicate: true,
+	})
+	if err != nil && err != docker.ErrNetworkAlreadyExists {
+		return err
+	}
+	return nil
+}
+
+// CreateTmpVolume makes a fresh tmpfs volume of at most size bytes that any user can write to, replacing what a
+// previous container left in it. The application can't write more than that to it, but what it writes is held in
+// memory, so it also counts towards the container's memory limit
+func CreateTmpVolume(client DockerClient, name string, size int64) error {
+	if err := removeVolume(client, name); err != nil {
+		return err
+	}
+	_, err := client.CreateVolume(docker.CreateVolumeOptions{
+		Name:   name,
+		Driver: "local",
+		DriverOpts: map[string]string{
+			"type":   "tmpfs",
+			"device": "tmpfs",
+			"o":      fmt.Sprintf("size=%d,mode=1777", size),
+		},
+	})
+	return err
+}
+
+func removeVolume(client DockerClient, name string) error {
+	if err := client.RemoveVolume(name); err != nil && err != docker.ErrNoSuchVolume {
+		return err
+	}
+	return nil
+}
+
 func DeleteContainer(client DockerClient, writer io.Writer, containerName string) error {
 	fmt.Fprintln(writer, "Deleting the CloudRocker container...")
 	containerID := GetContainerID(client, containerName)
@@ -128,6 +224,9 @@ func DeleteContainer(client DockerClient, writer io.Writer, containerName string
 	if err != nil {
 		log.Fatalf("Error: %s", err)
 	}
+	if err := removeVolume(client, config.TmpVolumeName(containerName)); err != nil {
+		log.Fatalf("Error: %s", err)
+	}
 	fmt.Fprintln(writer, "Deleted container.")
 	return nil
 }
@@ -139,14 +238,15 @@ func StopContainer(client DockerClient, writer io.Writer, containerName string)
 		log.Fatalf("Error: No such container: %s", containerName)
 	}
 	err := client.StopContainer(containerID, 10)
-	if err != nil {
+	if _, notRunning := err.(*docker.ContainerNotRunning); err != nil && !notRunning {
 		log.Fatalf("Error: %s", err)
 	}
 	fmt.Fprintln(writer, "Stopped your application.")
 	return nil
 }
 
-func RunStagingContainer(client DockerClient, writer io.Writer, containerConfig *config.ContainerConfig) error {
+// RunStagingContainer streams the staging container's output to writer until it exits, returning its exit status
+func RunStagingContainer(client DockerClient, writer io.Writer, containerConfig *config.ContainerConfig) (int, error) {
 	container := createContainer(client, writer, containerConfig)
 	return startAttached(client, writer, container)
 }
@@ -156,7 +256,22 @@ func RunRuntimeContainer(client DockerClient, writer io.Writer, containerConfig
 	return startDetached(client, writer, container)
 }
 
+func RunServiceContainer(client DockerClient, writer io.Writer, containerConfig *config.ContainerConfig) error {
+	container := createContainer(client, writer, containerConfig)
+	return startDetached(client, writer, container)
+}
+
 func createContainer(client DockerClient, writer io.Writer, containerConfig *config.ContainerConfig) *docker.Container {
+	if containerConfig.Network != "" {
+		if err := EnsureNetwork(client, writer, containerConfig.Network); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+	}
+	if containerConfig.DiskLimit > 0 {
+		if err := CreateTmpVolume(client, config.TmpVolumeName(containerConfig.ContainerName), containerConfig.DiskLimit); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+	}
 	fmt.Fprintln(writer, "Starting the CloudRocker container...")
 	var createOptions = ParseCreateContainerOptions(containerConfig)
 	if os.Getenv("DEBUG") == "true" {
@@ -171,10 +286,12 @@ func createContainer(client DockerClient, writer io.Writer, containerConfig *con
 	return container
 }
 
-func startAttached(client DockerClient, writer io.Writer, container *docker.Container) error {
-	_, err := client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
+// The container is waited on by its ID, so other containers stopping can't end the wait early
+func startAttached(client DockerClient, writer io.Writer, container *docker.Container) (int, error) {
+	attached, err := client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
 		Container:    container.ID,
 		OutputStream: writer,
+		ErrorStream:  writer,
 		Stdout:       true,
 		Stderr:       true,
 		Stream:       true,
@@ -183,20 +300,17 @@ func startAttached(client DockerClient, writer io.Writer, container *docker.Cont
 		log.Fatalf("Error: %s", err)
 	}
 
-	listener := make(chan *docker.APIEvents)
-	err = client.AddEventListener(listener)
-	if err != nil {
-		log.Fatalf("Error: %s", err)
-	}
-
 	startContainer(client, writer, container)
 
-	for {
-		msg := <-listener
-		if msg.Status == "die" {
-			return nil
-		}
+	exitCode, err := client.WaitContainer(container.ID)
+	if err != nil {
+		return 0, err
+	}
+	if attached != nil {
+		// the attached stream ends with the container, and is drained so none of its output is lost
+		attached.Wait()
 	}
+	return exitCode, nil
 }
 
 func startDetached(client DockerClient, writer io.Writer, container *docker.Container) error {
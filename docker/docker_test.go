@@ -1,11 +1,11 @@
 package docker_test
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/user"
-	"time"
 
 	"github.com/cloudcredo/cloudrocker/config"
 	"github.com/cloudcredo/cloudrocker/docker"
@@ -26,12 +26,22 @@ type FakeDockerClient struct {
 	removeContainerArg                 goDockerClient.RemoveContainerOptions
 	stopContainerArgID                 string
 	stopContainerArgTimeout            uint
+	stopContainerErr                   error
+	createNetworkErr                   error
 	createContainerArg                 goDockerClient.CreateContainerOptions
 	startContainerArgID                string
 	startContainerArgHostConfig        *goDockerClient.HostConfig
 	attachToContainerNonBlockingCalled bool
 	attachToContainerNonBlockingArg    goDockerClient.AttachToContainerOptions
-	addEventListenerCalled             bool
+	waitContainerArg                   string
+	exitCode                           int
+	inspectContainerArg                string
+	oomKilled                          bool
+	running                            bool
+	networkExists                      bool
+	createNetworkArg                   goDockerClient.CreateNetworkOptions
+	createVolumeArg                    goDockerClient.CreateVolumeOptions
+	removeVolumeArgs                   []string
 }
 
 func (fake *FakeDockerClient) Version() (*goDockerClient.Env, error) {
@@ -76,7 +86,7 @@ func (fake *FakeDockerClient) RemoveContainer(options goDockerClient.RemoveConta
 func (fake *FakeDockerClient) StopContainer(id string, timeout uint) error {
 	fake.stopContainerArgID = id
 	fake.stopContainerArgTimeout = timeout
-	return nil
+	return fake.stopContainerErr
 }
 
 func (fake *FakeDockerClient) CreateContainer(options goDockerClient.CreateContainerOptions) (*goDockerClient.Container, error) {
@@ -99,13 +109,43 @@ func (fake *FakeDockerClient) AttachToContainerNonBlocking(attachToContainerOpti
 	return nil, nil
 }
 
-func (fake *FakeDockerClient) AddEventListener(listener chan<- *goDockerClient.APIEvents) error {
-	fake.addEventListenerCalled = true
-	go func() {
-		time.Sleep(time.Nanosecond * 1000)
-		listener <- &goDockerClient.APIEvents{Status: "die"}
-	}()
-	return nil
+func (fake *FakeDockerClient) WaitContainer(id string) (int, error) {
+	fake.waitContainerArg = id
+	return fake.exitCode, nil
+}
+
+func (fake *FakeDockerClient) InspectContainer(id string) (*goDockerClient.Container, error) {
+	fake.inspectContainerArg = id
+	var container = goDockerClient.Container{
+		ID:    id,
+		State: goDockerClient.State{OOMKilled: fake.oomKilled, Running: fake.running, ExitCode: fake.exitCode},
+	}
+	return &container, nil
+}
+
+func (fake *FakeDockerClient) NetworkInfo(id string) (*goDockerClient.Network, error) {
+	if !fake.networkExists {
+		return nil, &goDockerClient.NoSuchNetwork{ID: id}
+	}
+	return &goDockerClient.Network{Name: id, ID: "7d86d31b1478"}, nil
+}
+
+func (fake *FakeDockerClient) CreateNetwork(options goDockerClient.CreateNetworkOptions) (*goDockerClient.Network, error) {
+	fake.createNetworkArg = options
+	if fake.createNetworkErr != nil {
+		return nil, fake.createNetworkErr
+	}
+	return &goDockerClient.Network{Name: options.Name, ID: "7d86d31b1478"}, nil
+}
+
+func (fake *FakeDockerClient) CreateVolume(options goDockerClient.CreateVolumeOptions) (*goDockerClient.Volume, error) {
+	fake.createVolumeArg = options
+	return &goDockerClient.Volume{Name: options.Name, Driver: options.Driver}, nil
+}
+
+func (fake *FakeDockerClient) RemoveVolume(name string) error {
+	fake.removeVolumeArgs = append(fake.removeVolumeArgs, name)
+	return goDockerClient.ErrNoSuchVolume
 }
 
 var _ = Describe("Docker", func() {
@@ -199,12 +239,62 @@ var _ = Describe("Docker", func() {
 		})
 	})
 
+	Describe("Finding out if a container ran out of memory", func() {
+		It("should report a container that was killed for exceeding its memory limit", func() {
+			fakeDockerClient = &FakeDockerClient{oomKilled: true}
+			Expect(docker.ContainerOOMKilled(fakeDockerClient, "cloudrocker-runtime")).To(Equal(true))
+			Expect(fakeDockerClient.inspectContainerArg).To(Equal("e8096241370a"))
+		})
+
+		It("should not report a container that is still healthy", func() {
+			fakeDockerClient = new(FakeDockerClient)
+			Expect(docker.ContainerOOMKilled(fakeDockerClient, "cloudrocker-runtime")).To(Equal(false))
+		})
+
+		It("should not report a container that does not exist", func() {
+			fakeDockerClient = &FakeDockerClient{oomKilled: true}
+			Expect(docker.ContainerOOMKilled(fakeDockerClient, "cloudrocker-staging")).To(Equal(false))
+			Expect(fakeDockerClient.inspectContainerArg).To(Equal(""))
+		})
+	})
+
+	Describe("Describing a container's state", func() {
+		It("should say when the container is running", func() {
+			fakeDockerClient = &FakeDockerClient{running: true}
+			Expect(docker.ContainerState(fakeDockerClient, "cloudrocker-runtime")).To(Equal("running"))
+		})
+
+		It("should say when the container ran out of memory", func() {
+			fakeDockerClient = &FakeDockerClient{oomKilled: true}
+			Expect(docker.ContainerState(fakeDockerClient, "cloudrocker-runtime")).To(ContainSubstring("killed for running out of memory"))
+		})
+
+		It("should give the exit status of a container that exited", func() {
+			fakeDockerClient = &FakeDockerClient{exitCode: 3}
+			Expect(docker.ContainerState(fakeDockerClient, "cloudrocker-runtime")).To(Equal("exited with status 3"))
+		})
+
+		It("should be empty for a container that does not exist", func() {
+			fakeDockerClient = new(FakeDockerClient)
+			Expect(docker.ContainerState(fakeDockerClient, "cloudrocker-staging")).To(Equal(""))
+		})
+	})
+
+	Describe("Inspecting a container", func() {
+		It("should tell whether the container is running", func() {
+			fakeDockerClient = &FakeDockerClient{running: true}
+			Expect(docker.ContainerRunning(fakeDockerClient, "cloudrocker-runtime")).To(Equal(true))
+			Expect(docker.ContainerRunning(fakeDockerClient, "cloudrocker-staging")).To(Equal(false))
+		})
+	})
+
 	Describe("Deleting the docker container", func() {
 		It("should tell Docker to delete the container", func() {
 			fakeDockerClient = new(FakeDockerClient)
 			docker.DeleteContainer(fakeDockerClient, buffer, "cloudrocker-runtime")
 			Expect(fakeDockerClient.removeContainerArg.Force).To(Equal(true))
 			Expect(fakeDockerClient.removeContainerArg.ID).To(Equal("e8096241370a"))
+			Expect(fakeDockerClient.removeVolumeArgs).To(Equal([]string{"cloudrocker-runtime-tmp"}))
 		})
 	})
 
@@ -216,6 +306,12 @@ var _ = Describe("Docker", func() {
 			var timeout uint = 10
 			Expect(fakeDockerClient.stopContainerArgTimeout).To(Equal(timeout))
 		})
+
+		It("should carry on when the container has already exited", func() {
+			fakeDockerClient = &FakeDockerClient{stopContainerErr: &goDockerClient.ContainerNotRunning{ID: "e8096241370a"}}
+			docker.StopContainer(fakeDockerClient, buffer, "cloudrocker-runtime")
+			Eventually(buffer).Should(gbytes.Say("Stopped your application."))
+		})
 	})
 
 	Describe("Building a runtime image", func() {
@@ -233,7 +329,7 @@ var _ = Describe("Docker", func() {
 
 		Context("without an image tag", func() {
 			BeforeEach(func() {
-				docker.BuildRuntimeImage(fakeDockerClient, buffer, config.NewRuntimeContainerConfig(dropletDir))
+				docker.BuildRuntimeImage(fakeDockerClient, buffer, config.NewRuntimeContainerConfig(dropletDir, config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080}))
 			})
 
 			It("should create a tarred version of the droplet mount, for extraction in the container, so as to not have AUFS permissions issues in https://github.com/docker/docker/issues/783", func() {
@@ -263,7 +359,7 @@ var _ = Describe("Docker", func() {
 
 		Context("with an image tag", func() {
 			It("should tell Docker to build the container from the Dockerfile", func() {
-				docker.BuildRuntimeImage(fakeDockerClient, buffer, config.NewRuntimeContainerConfig(dropletDir, "repository/image:tag"))
+				docker.BuildRuntimeImage(fakeDockerClient, buffer, config.NewRuntimeContainerConfig(dropletDir, config.NewApplication("/test/app"), config.Instance{Index: 0, HostPort: 8080}, "repository/image:tag"))
 
 				Expect(fakeDockerClient.buildImageArg.Name).To(Equal("repository/image:tag"))
 				Expect(fakeDockerClient.buildImageArg.ContextDir).To(Equal(dropletDir))
@@ -280,32 +376,39 @@ var _ = Describe("Docker", func() {
 			userID := thisUser.Uid
 			fakeDockerClient = new(FakeDockerClient)
 
-			docker.RunStagingContainer(fakeDockerClient, buffer, config.NewStageContainerConfig(config.NewDirectories("/test")))
+			exitCode, err := docker.RunStagingContainer(fakeDockerClient, buffer, config.NewStageContainerConfig(config.NewDirectories("/test", "app"), config.NewApplication("/test/app")))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(exitCode).To(Equal(0))
 
-			Expect(fakeDockerClient.createContainerArg.Name).To(Equal("cloudrocker-staging"))
+			Expect(fakeDockerClient.createContainerArg.Name).To(Equal("cloudrocker-staging-app"))
 			Expect(fakeDockerClient.createContainerArg.Config.User).To(Equal(userID))
-			Expect(fakeDockerClient.createContainerArg.Config.Env).To(Equal([]string{"CF_STACK=cflinuxfs2"}))
+			Expect(fakeDockerClient.createContainerArg.Config.Env).To(ContainElement("CF_STACK=cflinuxfs2"))
 			Expect(fakeDockerClient.createContainerArg.Config.Image).To(Equal("cloudrocker-base:latest"))
 			Expect(fakeDockerClient.createContainerArg.Config.Cmd).To(Equal([]string{"/rocker/rock", "stage", "internal"}))
 			var mounts = []goDockerClient.Mount{
 				goDockerClient.Mount{
-					Source:      "/test/buildpacks",
-					Destination: "/cloudrockerbuildpacks",
+					Source:      "/test/apps/app/deps",
+					Destination: "/app/.deps",
 					RW:          true,
 				},
 				goDockerClient.Mount{
-					Source:      "/test/rocker",
-					Destination: "/rocker",
+					Source:      "/test/apps/app/staging",
+					Destination: "/tmp/app",
 					RW:          true,
 				},
 				goDockerClient.Mount{
-					Source:      "/test/staging",
-					Destination: "/tmp/app",
+					Source:      "/test/apps/app/tmp",
+					Destination: "/tmp",
 					RW:          true,
 				},
 				goDockerClient.Mount{
-					Source:      "/test/tmp",
-					Destination: "/tmp",
+					Source:      "/test/buildpacks",
+					Destination: "/cloudrockerbuildpacks",
+					RW:          true,
+				},
+				goDockerClient.Mount{
+					Source:      "/test/rocker",
+					Destination: "/rocker",
 					RW:          true,
 				},
 			}
@@ -313,26 +416,89 @@ var _ = Describe("Docker", func() {
 			Expect(fakeDockerClient.createContainerArg.Config.AttachStdout).To(Equal(true))
 			Expect(fakeDockerClient.createContainerArg.Config.AttachStderr).To(Equal(true))
 			var binds = []string{
+				"/test/apps/app/deps:/app/.deps",
+				"/test/apps/app/staging:/tmp/app",
+				"/test/apps/app/tmp:/tmp",
 				"/test/buildpacks:/cloudrockerbuildpacks",
 				"/test/rocker:/rocker",
-				"/test/staging:/tmp/app",
-				"/test/tmp:/tmp",
 			}
 			Expect(fakeDockerClient.createContainerArg.HostConfig.Binds).To(Equal(binds))
-			Expect(fakeDockerClient.createContainerArg.HostConfig.NetworkMode).To(Equal("bridge"))
+			Expect(fakeDockerClient.createContainerArg.HostConfig.NetworkMode).To(Equal("cloudrocker"))
+			Expect(fakeDockerClient.createNetworkArg.Name).To(Equal("cloudrocker"))
+			Expect(fakeDockerClient.createNetworkArg.Driver).To(Equal("bridge"))
 
 			Expect(fakeDockerClient.attachToContainerNonBlockingArg).To(Equal(goDockerClient.AttachToContainerOptions{
 				Container:    "5716e9326cd9",
 				OutputStream: buffer,
+				ErrorStream:  buffer,
 				Stdout:       true,
 				Stderr:       true,
 				Stream:       true,
 			}))
-			Expect(fakeDockerClient.addEventListenerCalled).To(Equal(true))
+			Expect(fakeDockerClient.waitContainerArg).To(Equal("5716e9326cd9"))
 			Expect(fakeDockerClient.startContainerArgID).To(Equal("5716e9326cd9"))
 			var noHostConfig *goDockerClient.HostConfig
 			Expect(fakeDockerClient.startContainerArgHostConfig).To(Equal(noHostConfig))
 		})
+
+		It("should return the staging container's exit status", func() {
+			fakeDockerClient = new(FakeDockerClient)
+			fakeDockerClient.exitCode = 223
+			exitCode, err := docker.RunStagingContainer(fakeDockerClient, buffer, config.NewStageContainerConfig(config.NewDirectories("/test", "app"), config.NewApplication("/test/app")))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(exitCode).To(Equal(223))
+		})
+	})
+
+	Describe("Running a service container", func() {
+		It("should tell Docker to run the service's image as its own user", func() {
+			fakeDockerClient = new(FakeDockerClient)
+			containerConfig := config.NewServiceContainerConfig("cloudrocker-service-my-cache", "redis:latest", 6379, 49200,
+				map[string]string{}, []string{"redis-server", "--requirepass", "secret"})
+
+			docker.RunServiceContainer(fakeDockerClient, buffer, containerConfig)
+
+			Expect(fakeDockerClient.createContainerArg.Name).To(Equal("cloudrocker-service-my-cache"))
+			Expect(fakeDockerClient.createContainerArg.Config.User).To(Equal(""))
+			Expect(fakeDockerClient.createContainerArg.Config.Image).To(Equal("redis:latest"))
+			Expect(fakeDockerClient.createContainerArg.Config.Cmd).To(Equal([]string{"redis-server", "--requirepass", "secret"}))
+			Expect(fakeDockerClient.createContainerArg.HostConfig.PortBindings).To(Equal(map[goDockerClient.Port][]goDockerClient.PortBinding{
+				"6379/tcp": []goDockerClient.PortBinding{{HostPort: "49200"}},
+			}))
+			Expect(fakeDockerClient.createContainerArg.HostConfig.NetworkMode).To(Equal("cloudrocker"))
+			Expect(fakeDockerClient.attachToContainerNonBlockingCalled).To(Equal(false))
+			Expect(fakeDockerClient.startContainerArgID).To(Equal("5716e9326cd9"))
+		})
+	})
+
+	Describe("Creating the shared network", func() {
+		It("should create the network when it doesn't exist", func() {
+			fakeDockerClient = new(FakeDockerClient)
+			err := docker.EnsureNetwork(fakeDockerClient, buffer, "cloudrocker")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fakeDockerClient.createNetworkArg.Name).To(Equal("cloudrocker"))
+			Expect(fakeDockerClient.createNetworkArg.CheckDuplicate).To(Equal(true))
+			Eventually(buffer).Should(gbytes.Say("Creating the cloudrocker Docker network"))
+		})
+
+		It("should leave an existing network alone", func() {
+			fakeDockerClient = &FakeDockerClient{networkExists: true}
+			err := docker.EnsureNetwork(fakeDockerClient, buffer, "cloudrocker")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fakeDockerClient.createNetworkArg.Name).To(Equal(""))
+		})
+
+		It("should not mind another rock creating the network first", func() {
+			fakeDockerClient = &FakeDockerClient{createNetworkErr: goDockerClient.ErrNetworkAlreadyExists}
+			err := docker.EnsureNetwork(fakeDockerClient, buffer, "cloudrocker")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should return an error when the network can't be created", func() {
+			fakeDockerClient = &FakeDockerClient{createNetworkErr: errors.New("no more address pools")}
+			err := docker.EnsureNetwork(fakeDockerClient, buffer, "cloudrocker")
+			Expect(err).Should(MatchError("no more address pools"))
+		})
 	})
 
 	Describe("Running a runtime container", func() {
@@ -390,10 +556,35 @@ var _ = Describe("Docker", func() {
 			Expect(fakeDockerClient.createContainerArg.HostConfig.NetworkMode).To(Equal("bridge"))
 
 			Expect(fakeDockerClient.attachToContainerNonBlockingCalled).To(Equal(false))
-			Expect(fakeDockerClient.addEventListenerCalled).To(Equal(false))
+			Expect(fakeDockerClient.waitContainerArg).To(Equal(""))
 			Expect(fakeDockerClient.startContainerArgID).To(Equal("5716e9326cd9"))
 			var noHostConfig *goDockerClient.HostConfig
 			Expect(fakeDockerClient.startContainerArgHostConfig).To(Equal(noHostConfig))
+			Expect(fakeDockerClient.createVolumeArg.Name).To(Equal(""))
+		})
+
+		It("should give the container a fresh tmpfs volume limited to its disk quota", func() {
+			fakeDockerClient = new(FakeDockerClient)
+			containerConfig := testRuntimeContainerConfig()
+			containerConfig.DiskLimit = 256 * 1024 * 1024
+			containerConfig.Mounts[config.TmpVolumeName("cloudrocker-runtime")] = "/app/tmp"
+
+			docker.RunRuntimeContainer(fakeDockerClient, buffer, containerConfig)
+
+			Expect(fakeDockerClient.removeVolumeArgs).To(Equal([]string{"cloudrocker-runtime-tmp"}))
+			Expect(fakeDockerClient.createVolumeArg).To(Equal(goDockerClient.CreateVolumeOptions{
+				Name:   "cloudrocker-runtime-tmp",
+				Driver: "local",
+				DriverOpts: map[string]string{
+					"type":   "tmpfs",
+					"device": "tmpfs",
+					"o":      "size=268435456,mode=1777",
+				},
+			}))
+			Expect(fakeDockerClient.createContainerArg.HostConfig.Binds).To(Equal([]string{
+				"/home/testuser/testapp/app:/app",
+				"cloudrocker-runtime-tmp:/app/tmp",
+			}))
 		})
 	})
 })
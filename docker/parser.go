@@ -1,6 +1,8 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os/user"
@@ -16,7 +18,7 @@ func ParseCreateContainerOptions(config *config.ContainerConfig) docker.CreateCo
 	var options = docker.CreateContainerOptions{
 		Name: config.ContainerName,
 		Config: &docker.Config{
-			User:         userID(),
+			User:         parseUser(config.ImageUser),
 			Env:          parseEnvVars(config.EnvVars),
 			Image:        config.SrcImageTag,
 			Cmd:          config.Command,
@@ -28,16 +30,36 @@ func ParseCreateContainerOptions(config *config.ContainerConfig) docker.CreateCo
 		HostConfig: &docker.HostConfig{
 			Binds:        parseBinds(config.Mounts),
 			PortBindings: parsePublishedPorts(config.PublishedPorts),
-			NetworkMode:  "bridge",
+			NetworkMode:  parseNetworkMode(config.Network),
+			Links:        parseLinks(config.Links),
+			Memory:       config.MemoryLimit,
+			MemorySwap:   config.MemoryLimit, // no swap beyond the memory limit, as on Cloud Foundry
 		},
 	}
 	return options
 }
 
+func parseNetworkMode(network string) string {
+	if network == "" {
+		return "bridge"
+	}
+	return network
+}
+
+// Links give a container on a user-defined network its own DNS alias for another container
+func parseLinks(links map[string]string) []string {
+	var parsedLinks []string
+	for containerName, alias := range links {
+		parsedLinks = append(parsedLinks, containerName+":"+alias)
+	}
+	sort.Strings(parsedLinks)
+	return parsedLinks
+}
+
 func WriteRuntimeDockerfile(config *config.ContainerConfig) {
 	var dockerfile string
 
-	dockerfile = runtimeInitialDockerfileString()
+	dockerfile = runtimeInitialDockerfileString(config.PublishedPorts)
 	dockerfile = dockerfile + envVarDockerfileString(config.EnvVars)
 	dockerfile = dockerfile + commandDockerfileString(config.Command)
 
@@ -52,6 +74,13 @@ func WriteBaseImageDockerfile(config *config.ContainerConfig) {
 	ioutil.WriteFile(config.BaseConfigDir+"/Dockerfile", []byte(dockerfile), 0644)
 }
 
+func parseUser(imageUser bool) string {
+	if imageUser {
+		return ""
+	}
+	return userID()
+}
+
 func userID() string {
 	var thisUser *user.User
 	var err error
@@ -125,16 +154,28 @@ func parseDaemon(daemon bool) bool {
 	return !daemon
 }
 
-func runtimeInitialDockerfileString() string {
+func runtimeInitialDockerfileString(publishedPorts map[int]int) string {
 	return `FROM cloudrocker-base:latest
 COPY droplet.tgz /app/
 RUN chown vcap:vcap /app && cd /app && su vcap -c "tar zxf droplet.tgz" && rm droplet.tgz
-EXPOSE 8080
-USER vcap
+` + exposeDockerfileString(publishedPorts) + `USER vcap
 WORKDIR /app
 `
 }
 
+func exposeDockerfileString(publishedPorts map[int]int) string {
+	var containerPorts []int
+	for containerPort := range publishedPorts {
+		containerPorts = append(containerPorts, containerPort)
+	}
+	sort.Ints(containerPorts)
+	var exposeStrings []string
+	for _, containerPort := range containerPorts {
+		exposeStrings = append(exposeStrings, "EXPOSE "+strconv.Itoa(containerPort)+"\n")
+	}
+	return strings.Join(exposeStrings, "")
+}
+
 func baseImageDockerfileString(srcImageTag string) string {
 	return `FROM ` + srcImageTag + `
 RUN id vcap || /usr/sbin/useradd -mU -u ` + userID() + ` -d /app -s /bin/bash vcap
@@ -146,16 +187,32 @@ func envVarDockerfileString(envVars map[string]string) string {
 	var envVarStrings []string
 	for envVarKey, envVarVal := range envVars {
 		if envVarVal != "" {
-			envVarStrings = append(envVarStrings, "ENV "+envVarKey+" "+envVarVal+"\n")
+			envVarStrings = append(envVarStrings, "ENV "+envVarKey+"="+dockerfileQuote(envVarVal)+"\n")
 		}
 	}
 	sort.Strings(envVarStrings)
 	return strings.Join(envVarStrings, "")
 }
 
+var dockerfileEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`)
+
+// Quoted, so that Docker keeps spaces and quotes in the value and doesn't expand $variables. A Dockerfile
+// instruction ends at a newline, so JSON values such as a pretty-printed VCAP_SERVICES are compacted onto one
+// line, and newlines left in other values are escaped
+func dockerfileQuote(value string) string {
+	compacted := new(bytes.Buffer)
+	if strings.ContainsAny(value, "\r\n") && json.Compact(compacted, []byte(value)) == nil {
+		value = compacted.String()
+	}
+	return `"` + dockerfileEscaper.Replace(value) + `"`
+}
+
+// CMD is a JSON array, so its strings take JSON's escapes
+var commandEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
 func commandDockerfileString(command []string) string {
 	for index, commandElement := range command {
-		command[index] = strings.Replace(commandElement, `"`, `\"`, -1)
+		command[index] = commandEscaper.Replace(commandElement)
 	}
 	commandString := `CMD ["`
 	commandString = commandString + strings.Join(command, `", "`)
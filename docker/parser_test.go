@@ -21,34 +21,39 @@ var _ = Describe("Parser", func() {
 				os.Setenv("CLOUDROCKER_HOME", "/home/testuser/.cloudrocker")
 				thisUser, _ := user.Current()
 				userID := thisUser.Uid
-				stageConfig := config.NewStageContainerConfig(config.NewDirectories("/home/testuser/.cloudrocker"))
+				stageConfig := config.NewStageContainerConfig(config.NewDirectories("/home/testuser/.cloudrocker", "app"), config.NewApplication("/home/testuser/app"))
 
 				createContainerOptions := docker.ParseCreateContainerOptions(stageConfig)
 
-				Expect(createContainerOptions.Name).To(Equal("cloudrocker-staging"))
+				Expect(createContainerOptions.Name).To(Equal("cloudrocker-staging-app"))
 				Expect(createContainerOptions.Config.User).To(Equal(userID))
-				Expect(createContainerOptions.Config.Env).To(Equal([]string{"CF_STACK=cflinuxfs2"}))
+				Expect(createContainerOptions.Config.Env).To(ContainElement("CF_STACK=cflinuxfs2"))
 				Expect(createContainerOptions.Config.Image).To(Equal("cloudrocker-base:latest"))
 				Expect(createContainerOptions.Config.Cmd).To(Equal([]string{"/rocker/rock", "stage", "internal"}))
 				var mounts = []goDockerClient.Mount{
 					goDockerClient.Mount{
-						Source:      "/home/testuser/.cloudrocker/buildpacks",
-						Destination: "/cloudrockerbuildpacks",
+						Source:      "/home/testuser/.cloudrocker/apps/app/deps",
+						Destination: "/app/.deps",
 						RW:          true,
 					},
 					goDockerClient.Mount{
-						Source:      "/home/testuser/.cloudrocker/rocker",
-						Destination: "/rocker",
+						Source:      "/home/testuser/.cloudrocker/apps/app/staging",
+						Destination: "/tmp/app",
 						RW:          true,
 					},
 					goDockerClient.Mount{
-						Source:      "/home/testuser/.cloudrocker/staging",
-						Destination: "/tmp/app",
+						Source:      "/home/testuser/.cloudrocker/apps/app/tmp",
+						Destination: "/tmp",
 						RW:          true,
 					},
 					goDockerClient.Mount{
-						Source:      "/home/testuser/.cloudrocker/tmp",
-						Destination: "/tmp",
+						Source:      "/home/testuser/.cloudrocker/buildpacks",
+						Destination: "/cloudrockerbuildpacks",
+						RW:          true,
+					},
+					goDockerClient.Mount{
+						Source:      "/home/testuser/.cloudrocker/rocker",
+						Destination: "/rocker",
 						RW:          true,
 					},
 				}
@@ -56,13 +61,14 @@ var _ = Describe("Parser", func() {
 				Expect(createContainerOptions.Config.AttachStdout).To(Equal(true))
 				Expect(createContainerOptions.Config.AttachStderr).To(Equal(true))
 				var binds = []string{
+					"/home/testuser/.cloudrocker/apps/app/deps:/app/.deps",
+					"/home/testuser/.cloudrocker/apps/app/staging:/tmp/app",
+					"/home/testuser/.cloudrocker/apps/app/tmp:/tmp",
 					"/home/testuser/.cloudrocker/buildpacks:/cloudrockerbuildpacks",
 					"/home/testuser/.cloudrocker/rocker:/rocker",
-					"/home/testuser/.cloudrocker/staging:/tmp/app",
-					"/home/testuser/.cloudrocker/tmp:/tmp",
 				}
 				Expect(createContainerOptions.HostConfig.Binds).To(Equal(binds))
-				Expect(createContainerOptions.HostConfig.NetworkMode).To(Equal("bridge"))
+				Expect(createContainerOptions.HostConfig.NetworkMode).To(Equal("cloudrocker"))
 			})
 		})
 
@@ -116,6 +122,34 @@ var _ = Describe("Parser", func() {
 				}
 				Expect(createContainerOptions.HostConfig.PortBindings).To(Equal(portBindings))
 				Expect(createContainerOptions.HostConfig.NetworkMode).To(Equal("bridge"))
+				Expect(createContainerOptions.HostConfig.Memory).To(Equal(int64(0)))
+			})
+
+			It("should join the container's network, with a DNS alias for each linked container", func() {
+				testRuntimeContainerConfig := testRuntimeContainerConfig()
+				testRuntimeContainerConfig.Network = "cloudrocker"
+				testRuntimeContainerConfig.Links = map[string]string{
+					"cloudrocker-service-my-db":    "my-db",
+					"cloudrocker-service-my-cache": "my-cache",
+				}
+
+				createContainerOptions := docker.ParseCreateContainerOptions(testRuntimeContainerConfig)
+
+				Expect(createContainerOptions.HostConfig.NetworkMode).To(Equal("cloudrocker"))
+				Expect(createContainerOptions.HostConfig.Links).To(Equal([]string{
+					"cloudrocker-service-my-cache:my-cache",
+					"cloudrocker-service-my-db:my-db",
+				}))
+			})
+
+			It("should limit the container's memory when the application asks for it", func() {
+				testRuntimeContainerConfig := testRuntimeContainerConfig()
+				testRuntimeContainerConfig.MemoryLimit = 512 * 1024 * 1024
+
+				createContainerOptions := docker.ParseCreateContainerOptions(testRuntimeContainerConfig)
+
+				Expect(createContainerOptions.HostConfig.Memory).To(Equal(int64(512 * 1024 * 1024)))
+				Expect(createContainerOptions.HostConfig.MemorySwap).To(Equal(int64(512 * 1024 * 1024)))
 			})
 		})
 	})
@@ -141,6 +175,37 @@ RUN mkdir -p /app/tmp && chown -R vcap:vcap /app
 				os.RemoveAll(tmpBaseConfigDir)
 			})
 		})
+
+		Context("with a runtime config with awkward env var values", func() {
+			It("should escape the values so that Docker keeps them intact", func() {
+				tmpDropletDir, err := ioutil.TempDir(os.TempDir(), "parser-test-runtime")
+				Expect(err).ShouldNot(HaveOccurred())
+				testRuntimeContainerConfig := testRuntimeContainerConfig()
+				testRuntimeContainerConfig.DropletDir = tmpDropletDir
+				testRuntimeContainerConfig.EnvVars = map[string]string{
+					"GREETING": `hello "big" world`,
+					"JSON":     `{"a": "b"}`,
+					"LINES":    "first line\r\nsecond line",
+					"PATHS":    `C:\app $HOME`,
+					"PRETTY":   "{\n  \"services\": [\n    \"my-db\"\n  ]\n}\n",
+				}
+				testRuntimeContainerConfig.Command = []string{"/bin/bash", "-c", "echo one\necho two"}
+
+				docker.WriteRuntimeDockerfile(testRuntimeContainerConfig)
+
+				result, err := ioutil.ReadFile(tmpDropletDir + "/Dockerfile")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(string(result)).To(ContainSubstring(`ENV GREETING="hello \"big\" world"
+ENV JSON="{\"a\": \"b\"}"
+ENV LINES="first line\r\nsecond line"
+ENV PATHS="C:\\app \$HOME"
+ENV PRETTY="{\"services\":[\"my-db\"]}"
+CMD ["/bin/bash", "-c", "echo one\necho two"]
+`))
+
+				os.RemoveAll(tmpDropletDir)
+			})
+		})
 	})
 })
 
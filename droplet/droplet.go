@@ -0,0 +1,129 @@
+package droplet
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cloudcredo/cloudrocker/config"
+	"github.com/cloudcredo/cloudrocker/utils"
+)
+
+// Export copies a staged droplet to a file. Droplets are gzipped tarballs laid out as Cloud Foundry lays them out,
+// so the file can be imported again, here or on another machine
+func Export(dropletPath string, file string) error {
+	if _, err := os.Stat(dropletPath); err != nil {
+		return fmt.Errorf("There is no droplet to export - has the application been staged?")
+	}
+	return utils.Cp(dropletPath, file)
+}
+
+// Import checks that a file is a droplet, then writes it to dropletPath. Cloud Foundry keeps a multi-buildpack
+// droplet's deps and profile.d beside its app directory, where the runtime's mount of the app directory can't
+// see them, so they are moved inside it as they are copied
+func Import(file string, dropletPath string) error {
+	source, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	gzipReader, err := gzip.NewReader(source)
+	if err != nil {
+		return fmt.Errorf("%s is not a droplet - droplets are gzipped tarballs: %s", file, err)
+	}
+	defer gzipReader.Close()
+
+	dest, err := os.Create(dropletPath)
+	if err != nil {
+		return err
+	}
+	gzipWriter := gzip.NewWriter(dest)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	hasStagingInfo, hasApp, err := copyEntries(tar.NewReader(gzipReader), tarWriter)
+	if err == nil {
+		err = tarWriter.Close()
+	}
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && (!hasStagingInfo || !hasApp) {
+		err = fmt.Errorf("%s is not a droplet - it has no staging_info.yml and app directory", file)
+	}
+	if err != nil {
+		os.Remove(dropletPath)
+		return err
+	}
+	return nil
+}
+
+func copyEntries(tarReader *tar.Reader, tarWriter *tar.Writer) (hasStagingInfo bool, hasApp bool, err error) {
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return hasStagingInfo, hasApp, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("Failed to read the droplet: %s", err)
+		}
+		name, err := entryName(header.Name)
+		if err != nil {
+			return false, false, err
+		}
+		if name == "staging_info.yml" {
+			hasStagingInfo = true
+		}
+		if name == "app" || strings.HasPrefix(name, "app/") {
+			hasApp = true
+		}
+		header.Name = "./" + name
+		if name == "." {
+			header.Name = "./"
+		} else if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			linkname, err := entryName(header.Linkname)
+			if err != nil {
+				return false, false, err
+			}
+			header.Linkname = "./" + linkname
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return false, false, err
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			return false, false, err
+		}
+	}
+}
+
+// entryName is where an entry belongs in the imported droplet. Entries that would be extracted outside the
+// droplet directory are refused
+func entryName(name string) (string, error) {
+	cleaned := strings.TrimPrefix(path.Clean(name), "./")
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("Refusing to import the droplet - its entry %s is outside the droplet", name)
+	}
+	return relocate(cleaned), nil
+}
+
+// relocate moves Cloud Foundry's top level deps and profile.d directories inside the app directory
+func relocate(name string) string {
+	for _, dir := range []struct{ from, to string }{
+		{"deps", "app/" + config.DepsDir},
+		{"profile.d", "app/.profile.d"},
+	} {
+		if name == dir.from || strings.HasPrefix(name, dir.from+"/") {
+			return dir.to + strings.TrimPrefix(name, dir.from)
+		}
+	}
+	return name
+}
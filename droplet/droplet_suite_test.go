@@ -0,0 +1,13 @@
+package droplet_test
+
+import (
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDroplet(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Droplet Suite")
+}
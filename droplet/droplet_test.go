@@ -0,0 +1,100 @@
+package droplet_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/cloudcredo/cloudrocker/droplet"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+)
+
+var _ = Describe("Droplet", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		tmpDir, _ = ioutil.TempDir(os.TempDir(), "droplet-test")
+		err := exec.Command("tar", "-czf", tmpDir+"/cf-droplet.tgz", "-C", "fixtures/cfdroplet", ".").Run()
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("Importing a droplet", func() {
+		It("should move a Cloud Foundry droplet's deps and profile.d inside its app directory", func() {
+			err := droplet.Import(tmpDir+"/cf-droplet.tgz", tmpDir+"/droplet")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			os.MkdirAll(tmpDir+"/unpacked", 0755)
+			err = exec.Command("tar", "-xzf", tmpDir+"/droplet", "-C", tmpDir+"/unpacked").Run()
+			Expect(err).ShouldNot(HaveOccurred())
+			for _, file := range []string{"staging_info.yml", "app/app.py", "app/.deps/0/config.yml", "app/.profile.d/000_multi-supply.sh"} {
+				_, err := os.Stat(tmpDir + "/unpacked/" + file)
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+			info, err := os.Stat(tmpDir + "/unpacked/app/.deps/0/bin/tool")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+			_, err = os.Stat(tmpDir + "/unpacked/deps")
+			Expect(os.IsNotExist(err)).To(Equal(true))
+		})
+
+		It("should refuse a file that isn't a droplet", func() {
+			ioutil.WriteFile(tmpDir+"/not-a-droplet", []byte("not a droplet"), 0644)
+			err := droplet.Import(tmpDir+"/not-a-droplet", tmpDir+"/droplet")
+			Expect(err).Should(HaveOccurred())
+			_, err = os.Stat(tmpDir + "/droplet")
+			Expect(os.IsNotExist(err)).To(Equal(true))
+		})
+
+		It("should refuse a tarball without staging_info.yml", func() {
+			err := exec.Command("tar", "-czf", tmpDir+"/app.tgz", "-C", "fixtures/cfdroplet/app", ".").Run()
+			Expect(err).ShouldNot(HaveOccurred())
+			err = droplet.Import(tmpDir+"/app.tgz", tmpDir+"/droplet")
+			Expect(err).Should(MatchError(tmpDir + "/app.tgz is not a droplet - it has no staging_info.yml and app directory"))
+			_, err = os.Stat(tmpDir + "/droplet")
+			Expect(os.IsNotExist(err)).To(Equal(true))
+		})
+
+		It("should refuse a tarball with an entry outside the droplet", func() {
+			for _, name := range []string{"./../escaped.txt", "app/../../escaped.txt", "/tmp/escaped.txt"} {
+				file, _ := os.Create(tmpDir + "/crafted.tgz")
+				gzipWriter := gzip.NewWriter(file)
+				tarWriter := tar.NewWriter(gzipWriter)
+				for _, entry := range []string{"./staging_info.yml", "./app/app.py", name} {
+					tarWriter.WriteHeader(&tar.Header{Name: entry, Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+					tarWriter.Write([]byte("data"))
+				}
+				tarWriter.Close()
+				gzipWriter.Close()
+				file.Close()
+
+				err := droplet.Import(tmpDir+"/crafted.tgz", tmpDir+"/droplet")
+				Expect(err).Should(MatchError("Refusing to import the droplet - its entry " + name + " is outside the droplet"))
+				_, err = os.Stat(tmpDir + "/droplet")
+				Expect(os.IsNotExist(err)).To(Equal(true))
+			}
+		})
+	})
+
+	Describe("Exporting a droplet", func() {
+		It("should copy the droplet to the file", func() {
+			err := droplet.Export(tmpDir+"/cf-droplet.tgz", tmpDir+"/exported.tgz")
+			Expect(err).ShouldNot(HaveOccurred())
+			original, _ := ioutil.ReadFile(tmpDir + "/cf-droplet.tgz")
+			exported, _ := ioutil.ReadFile(tmpDir + "/exported.tgz")
+			Expect(exported).To(Equal(original))
+		})
+
+		It("should say when there is no droplet to export", func() {
+			err := droplet.Export(tmpDir+"/missing", tmpDir+"/exported.tgz")
+			Expect(err).Should(MatchError("There is no droplet to export - has the application been staged?"))
+		})
+	})
+})
@@ -0,0 +1 @@
+export PATH=$DEPS_DIR/0/bin:$PATH
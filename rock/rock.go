@@ -4,11 +4,51 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/codegangsta/cli"
+	"github.com/cloudcredo/cloudrocker/buildpack"
 	"github.com/cloudcredo/cloudrocker/rocker"
+	"github.com/cloudcredo/cloudrocker/service"
+	"github.com/cloudcredo/cloudrocker/stager"
 )
 
+var portFlag = cli.StringFlag{
+	Name:  "port",
+	Usage: "host port for the application, or 'auto' to pick a free one (default: the last port used, or 8080)",
+}
+
+var memoryFlag = cli.StringFlag{
+	Name:  "memory",
+	Usage: "memory limit for the application, e.g. 256M or 1G (default: the manifest's memory, or no limit)",
+}
+
+var processFlag = cli.StringFlag{
+	Name:  "process",
+	Usage: "the Procfile process type to use (default: web)",
+}
+
+var buildpackFlag = cli.StringSliceFlag{
+	Name:  "b",
+	Value: &cli.StringSlice{},
+	Usage: "an installed buildpack's name or a buildpack URL to use without detection, overriding the manifest - repeat for multiple buildpacks",
+}
+
+var forceStageFlag = cli.BoolFlag{
+	Name:  "force-stage",
+	Usage: "stage the application even if it, its buildpacks and its staging environment haven't changed since it was last staged",
+}
+
+var proxyFlag = cli.BoolFlag{
+	Name:  "proxy",
+	Usage: "pass the host's HTTP_PROXY, HTTPS_PROXY and NO_PROXY to staging",
+}
+
+var commandFlag = cli.StringFlag{
+	Name:  "c",
+	Usage: "start command for the web process, overriding the manifest, Procfile and buildpack",
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "rock"
@@ -44,19 +84,36 @@ func main() {
 		{
 			Name:  "up",
 			Usage: "stage and run the application",
+			Flags: []cli.Flag{portFlag, memoryFlag, buildpackFlag, commandFlag, forceStageFlag, proxyFlag},
 			Action: func(c *cli.Context) {
 				rocker := rocker.NewRocker()
+				if err := rocker.SetMemory(c.String("memory")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+				rocker.SetBuildpacks(c.StringSlice("b"))
+				rocker.SetCommand(c.String("c"))
+				rocker.SetForceStage(c.Bool("force-stage"))
+				rocker.SetProxyPassthrough(c.Bool("proxy"))
 				if err := rocker.RunStager(os.Stdout); err != nil {
 					log.Fatalf(" %s", err)
 				}
-				rocker.RunRuntime(os.Stdout)
+				rocker.RunRuntime(os.Stdout, c.String("port"))
 			},
 		},
 		{
 			Name:  "build",
 			Usage: "build [user/image:tag] - build a runnable image of the application, optional tagging",
+			Flags: []cli.Flag{memoryFlag, processFlag, buildpackFlag, commandFlag, forceStageFlag, proxyFlag},
 			Action: func(c *cli.Context) {
 				rocker := rocker.NewRocker()
+				if err := rocker.SetMemory(c.String("memory")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+				rocker.SetProcess(c.String("process"))
+				rocker.SetBuildpacks(c.StringSlice("b"))
+				rocker.SetCommand(c.String("c"))
+				rocker.SetForceStage(c.Bool("force-stage"))
+				rocker.SetProxyPassthrough(c.Bool("proxy"))
 				if err := rocker.RunStager(os.Stdout); err != nil {
 					log.Fatalf(" %s", err)
 				}
@@ -67,6 +124,169 @@ func main() {
 				}
 			},
 		},
+		{
+			Name:  "env",
+			Usage: "show the application's staging and runtime environment, and where each value came from",
+			Flags: []cli.Flag{
+				portFlag,
+				memoryFlag,
+				cli.BoolFlag{Name: "json", Usage: "output the environment as JSON"},
+			},
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if err := rocker.SetMemory(c.String("memory")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+				if err := rocker.PrintEnv(os.Stdout, c.Bool("json"), c.String("port")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "set-env",
+			Usage: "set-env [NAME] [VALUE] - set an env var for the application",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if len(c.Args()) != 2 {
+					fmt.Println("Please supply an env var name and value")
+					return
+				}
+				if err := rocker.SetEnv(os.Stdout, c.Args().Get(0), c.Args().Get(1)); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "unset-env",
+			Usage: "unset-env [NAME] - remove an env var set with set-env",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if name := c.Args().First(); name != "" {
+					if err := rocker.UnsetEnv(os.Stdout, name); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply an env var name to unset")
+				}
+			},
+		},
+		{
+			Name:  "set-staging-env",
+			Usage: "set-staging-env [NAME] [VALUE] - set an env var only staging sees, such as a package registry token",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if len(c.Args()) != 2 {
+					fmt.Println("Please supply an env var name and value")
+					return
+				}
+				if err := rocker.SetStagingEnv(os.Stdout, c.Args().Get(0), c.Args().Get(1)); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "unset-staging-env",
+			Usage: "unset-staging-env [NAME] - remove an env var set with set-staging-env",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if name := c.Args().First(); name != "" {
+					if err := rocker.UnsetStagingEnv(os.Stdout, name); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply an env var name to unset")
+				}
+			},
+		},
+		{
+			Name:      "staging-environment-variable-group",
+			ShortName: "sevg",
+			Usage:     "show the env vars every application is staged with",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if err := rocker.PrintStagingEnvGroup(os.Stdout); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:      "set-staging-environment-variable-group",
+			ShortName: "ssevg",
+			Usage:     "set-staging-environment-variable-group '{\"NAME\":\"value\"}' - set the env vars every application is staged with",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if groupJSON := c.Args().First(); groupJSON != "" {
+					if err := rocker.SetStagingEnvGroup(os.Stdout, groupJSON); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply the env vars as a JSON object")
+				}
+			},
+		},
+		{
+			Name:  "info",
+			Usage: "show the application's last staging and what Cloud Rocker keeps for it",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if err := rocker.PrintInfo(os.Stdout); err != nil {
+					log.Fatalf(" %s", err)
+				}
+				if err := rocker.PrintInstances(os.Stdout); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "manage the application's build artifact cache",
+			Subcommands: []cli.Command{
+				{
+					Name:  "clear",
+					Usage: "clear the build artifact cache, so the next staging starts afresh",
+					Action: func(c *cli.Context) {
+						rocker := rocker.NewRocker()
+						if err := rocker.ClearCache(os.Stdout); err != nil {
+							log.Fatalf(" %s", err)
+						}
+					},
+				},
+			},
+		},
+		{
+			Name:  "droplet",
+			Usage: "export or import the application's droplet",
+			Subcommands: []cli.Command{
+				{
+					Name:  "export",
+					Usage: "droplet export FILE - save the staged droplet to a file",
+					Action: func(c *cli.Context) {
+						rocker := rocker.NewRocker()
+						if file := c.Args().First(); file != "" {
+							if err := rocker.ExportDroplet(os.Stdout, file); err != nil {
+								log.Fatalf(" %s", err)
+							}
+						} else {
+							fmt.Println("Please supply a file to export the droplet to")
+						}
+					},
+				},
+				{
+					Name:  "import",
+					Usage: "droplet import FILE - use a droplet from a file, such as one downloaded from Cloud Foundry, instead of staging",
+					Action: func(c *cli.Context) {
+						rocker := rocker.NewRocker()
+						if file := c.Args().First(); file != "" {
+							if err := rocker.ImportDroplet(os.Stdout, file); err != nil {
+								log.Fatalf(" %s", err)
+							}
+						} else {
+							fmt.Println("Please supply a droplet file to import")
+						}
+					},
+				},
+			},
+		},
 		{
 			Name:  "off",
 			Usage: "stop the application container and remove it",
@@ -77,21 +297,67 @@ func main() {
 		},
 		{
 			Name:  "buildpacks",
-			Usage: "show the buildpacks installed on the local system",
+			Usage: "show the buildpacks installed on the local system, in detect order",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "v", Usage: "show each buildpack's version, commit, install time and source"},
+			},
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if err := rocker.ListBuildpacks(os.Stdout, c.Bool("v")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "update-buildpack",
+			Usage: "update-buildpack [BUILDPACK] - fetch a buildpack again from the source it was added from, pinning its latest version",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "all", Usage: "update every installed buildpack"},
+			},
 			Action: func(c *cli.Context) {
 				rocker := rocker.NewRocker()
-				rocker.ListBuildpacks(os.Stdout)
+				name := c.Args().First()
+				if c.Bool("all") {
+					name = ""
+				} else if name == "" {
+					fmt.Println("Please supply a buildpack to update, or --all")
+					return
+				}
+				if err := rocker.UpdateBuildpack(os.Stdout, name); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "buildpack-order",
+			Usage: "buildpack-order [BUILDPACK...] - detect these buildpacks first, in this order",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if len(c.Args()) == 0 {
+					fmt.Println("Please supply the buildpacks to detect first, in order")
+					return
+				}
+				if err := rocker.SetBuildpackOrder(os.Stdout, c.Args()); err != nil {
+					log.Fatalf(" %s", err)
+				}
 			},
 		},
 		{
 			Name:  "add-buildpack",
-			Usage: "add-buildpack [URL] - add a buildpack from a GitHub URL to the local system",
+			Usage: "add-buildpack [URL|ZIP|DIRECTORY] - add a buildpack from a git URL (pin a branch, tag or commit with #ref), a zip file or a local directory",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "name", Usage: "the name to install the buildpack as (default: the repository, zip file or directory name)"},
+				cli.BoolFlag{Name: "link", Usage: "symlink a local directory rather than copying it, so changes to it are used by the next staging"},
+			},
 			Action: func(c *cli.Context) {
 				rocker := rocker.NewRocker()
-				if url := c.Args().First(); url != "" {
-					rocker.AddBuildpack(os.Stdout, url)
+				if source := c.Args().First(); source != "" {
+					options := buildpack.AddOptions{Name: c.String("name"), Link: c.Bool("link")}
+					if err := rocker.AddBuildpack(os.Stdout, source, options); err != nil {
+						log.Fatalf(" %s", err)
+					}
 				} else {
-					fmt.Println("Please supply a GitHub URL to download")
+					fmt.Println("Please supply a buildpack URL, zip file or directory to add")
 				}
 			},
 		},
@@ -107,15 +373,149 @@ func main() {
 				}
 			},
 		},
+		{
+			Name:  "create-service",
+			Usage: "create-service [SERVICE] [NAME] - create a service instance in a local container (" + strings.Join(service.TypeLabels(), ", ") + ") or from a service broker",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "plan", Usage: "the service plan to use (default: the service's first plan)"},
+			},
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if len(c.Args()) != 2 {
+					fmt.Println("Please supply a service type and a name for the service instance")
+					return
+				}
+				if err := rocker.CreateService(os.Stdout, c.Args().Get(0), c.Args().Get(1), c.String("plan")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "add-service-broker",
+			Usage: "add-service-broker [NAME] [URL] - register an Open Service Broker API broker, to create services from",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "username", Usage: "username for the broker's basic auth"},
+				cli.StringFlag{Name: "password", Usage: "password for the broker's basic auth"},
+			},
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if len(c.Args()) != 2 {
+					fmt.Println("Please supply a name and a URL for the service broker")
+					return
+				}
+				if err := rocker.AddServiceBroker(os.Stdout, c.Args().Get(0), c.Args().Get(1), c.String("username"), c.String("password")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "delete-service-broker",
+			Usage: "delete-service-broker [NAME] - forget a service broker once its service instances are deleted",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if name := c.Args().First(); name != "" {
+					if err := rocker.DeleteServiceBroker(os.Stdout, name); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply a service broker to delete")
+				}
+			},
+		},
+		{
+			Name:  "marketplace",
+			Usage: "show the services that can be created, locally and from service brokers",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if err := rocker.Marketplace(os.Stdout); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "create-user-provided-service",
+			Usage: "create-user-provided-service [NAME] -p '{\"key\":\"value\"}' - make a service running elsewhere available to bind",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "p", Usage: "credentials, as a JSON object or the path to a file holding one"},
+				cli.StringFlag{Name: "l", Usage: "syslog drain URL"},
+			},
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if name := c.Args().First(); name != "" {
+					if err := rocker.CreateUserProvidedService(os.Stdout, name, c.String("p"), c.String("l")); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply a name for the service instance")
+				}
+			},
+		},
+		{
+			Name:  "services",
+			Usage: "show the service instances created on the local system",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if err := rocker.ListServices(os.Stdout); err != nil {
+					log.Fatalf(" %s", err)
+				}
+			},
+		},
+		{
+			Name:  "delete-service",
+			Usage: "delete-service [NAME] - delete a service instance and its container",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if name := c.Args().First(); name != "" {
+					if err := rocker.DeleteService(os.Stdout, name); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply a service instance to delete")
+				}
+			},
+		},
+		{
+			Name:  "bind-service",
+			Usage: "bind-service [NAME] - bind a service instance to the application, adding it to VCAP_SERVICES",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if name := c.Args().First(); name != "" {
+					if err := rocker.BindService(os.Stdout, name); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply a service instance to bind")
+				}
+			},
+		},
+		{
+			Name:  "unbind-service",
+			Usage: "unbind-service [NAME] - unbind a service instance from the application",
+			Action: func(c *cli.Context) {
+				rocker := rocker.NewRocker()
+				if name := c.Args().First(); name != "" {
+					if err := rocker.UnbindService(os.Stdout, name); err != nil {
+						log.Fatalf(" %s", err)
+					}
+				} else {
+					fmt.Println("Please supply a service instance to unbind")
+				}
+			},
+		},
 		{
 			Name:  "stage",
 			Usage: "only execute the staging phase for the application",
+			Flags: []cli.Flag{buildpackFlag, forceStageFlag, proxyFlag},
 			Action: func(c *cli.Context) {
 				rocker := rocker.NewRocker()
+				rocker.SetBuildpacks(c.StringSlice("b"))
+				rocker.SetForceStage(c.Bool("force-stage"))
+				rocker.SetProxyPassthrough(c.Bool("proxy"))
 				if internal := c.Args().First(); internal == "internal" {
 					//this is rocker being called inside the staging container
-					if err := rocker.StageApp(os.Stdout); err != nil {
-						fmt.Printf(" %s", err)
+					if err := rocker.StageApp(os.Stdout, c.Args().Tail()); err != nil {
+						fmt.Fprintf(os.Stderr, " %s\n", err)
+						os.Exit(stager.ExitCode(err))
 					}
 				} else {
 					//this is rocker being called by the user, outside of the staging container
@@ -128,9 +528,15 @@ func main() {
 		{
 			Name:  "run",
 			Usage: "only run the current staged application",
+			Flags: []cli.Flag{portFlag, memoryFlag, processFlag, commandFlag},
 			Action: func(c *cli.Context) {
 				rocker := rocker.NewRocker()
-				rocker.RunRuntime(os.Stdout)
+				if err := rocker.SetMemory(c.String("memory")); err != nil {
+					log.Fatalf(" %s", err)
+				}
+				rocker.SetProcess(c.String("process"))
+				rocker.SetCommand(c.String("c"))
+				rocker.RunRuntime(os.Stdout, c.String("port"))
 			},
 		},
 	}
@@ -1,16 +1,26 @@
 package rocker
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/pivotal-golang/archiver/extractor"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/pivotal-golang/bytefmt"
+	"github.com/cloudcredo/cloudrocker/appfiles"
+	"github.com/cloudcredo/cloudrocker/broker"
 	"github.com/cloudcredo/cloudrocker/buildpack"
 	"github.com/cloudcredo/cloudrocker/config"
 	"github.com/cloudcredo/cloudrocker/docker"
+	"github.com/cloudcredo/cloudrocker/droplet"
+	"github.com/cloudcredo/cloudrocker/service"
 	"github.com/cloudcredo/cloudrocker/stager"
 	"github.com/cloudcredo/cloudrocker/utils"
 )
@@ -18,12 +28,336 @@ import (
 type Rocker struct {
 	Stdout      *io.PipeReader
 	directories *config.Directories
+	application *config.Application
+	process     string
+	forceStage  bool
 }
 
 func NewRocker() *Rocker {
+	appDir := utils.Pwd()
+	manifest, err := config.ParseManifest(appDir)
+	if err != nil {
+		log.Fatalf(" %s", err)
+	}
+	application := manifest.Application(appDir)
+	directories := config.NewDirectories(utils.CloudrockerHome(), application.Slug())
+	if application.UserEnv, err = config.ReadUserEnv(directories.AppHome()); err != nil {
+		log.Fatalf(" %s", err)
+	}
+	if application.StagingEnv, err = config.ReadStagingEnv(directories.AppHome()); err != nil {
+		log.Fatalf(" %s", err)
+	}
+	if application.StagingEnvGroup, err = config.ReadStagingEnvGroup(directories.Home()); err != nil {
+		log.Fatalf(" %s", err)
+	}
+	if application.ServiceBindings, err = loadServiceBindings(directories, application.Slug()); err != nil {
+		log.Fatalf(" %s", err)
+	}
 	return &Rocker{
-		directories: config.NewDirectories(utils.CloudrockerHome()),
+		directories: directories,
+		application: application,
+		process:     config.WebProcess,
+	}
+}
+
+// SetCommand overrides the manifest's command, and any start command from the Procfile or buildpack, for the web process
+func (f *Rocker) SetCommand(command string) {
+	if command != "" {
+		f.application.Command = command
+	}
+}
+
+// SetBuildpacks overrides the manifest's buildpacks with installed buildpacks' names or buildpack URLs. More than
+// one stages with every buildpack, the last one finalizing the application
+func (f *Rocker) SetBuildpacks(buildpacks []string) {
+	if len(buildpacks) > 0 {
+		f.application.Buildpack = ""
+		f.application.Buildpacks = buildpacks
+	}
+}
+
+// SetProcess chooses the Procfile process type to run or build, leaving it as web when process is empty
+func (f *Rocker) SetProcess(process string) {
+	if process != "" {
+		f.process = process
+	}
+}
+
+// SetForceStage stages the application even when nothing it depends on has changed since it was last staged
+func (f *Rocker) SetForceStage(force bool) {
+	f.forceStage = force
+}
+
+// SetProxyPassthrough passes the host's HTTP_PROXY, HTTPS_PROXY and NO_PROXY to staging, for buildpacks that
+// download through a proxy
+func (f *Rocker) SetProxyPassthrough(passthrough bool) {
+	if passthrough {
+		f.application.ProxyEnv = config.HostProxyEnv()
+	}
+}
+
+// SetMemory overrides the manifest's memory limit for this run
+func (f *Rocker) SetMemory(memory string) error {
+	return f.application.SetMemory(memory)
+}
+
+// SetEnv saves an env var for the application, to be used from its next staging or run onwards
+func (f *Rocker) SetEnv(writer io.Writer, name string, value string) error {
+	if err := config.ValidateEnvVarName(name); err != nil {
+		return err
+	}
+	f.application.UserEnv[name] = value
+	if err := config.WriteUserEnv(f.directories.AppHome(), f.application.UserEnv); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Set %s for %s. Use 'rock up' or 'rock run' to pick up the change.\n", name, f.application.Name)
+	return nil
+}
+
+func (f *Rocker) UnsetEnv(writer io.Writer, name string) error {
+	if _, present := f.application.UserEnv[name]; !present {
+		fmt.Fprintf(writer, "%s is not set for %s.\n", name, f.application.Name)
+		return nil
+	}
+	delete(f.application.UserEnv, name)
+	if err := config.WriteUserEnv(f.directories.AppHome(), f.application.UserEnv); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Unset %s for %s. Use 'rock up' or 'rock run' to pick up the change.\n", name, f.application.Name)
+	return nil
+}
+
+// SetStagingEnv saves an env var that only staging sees, such as a private package registry's token
+func (f *Rocker) SetStagingEnv(writer io.Writer, name string, value string) error {
+	if err := config.ValidateEnvVarName(name); err != nil {
+		return err
+	}
+	f.application.StagingEnv[name] = value
+	if err := config.WriteStagingEnv(f.directories.AppHome(), f.application.StagingEnv); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Set %s for staging %s. Use 'rock up' or 'rock stage' to pick up the change.\n", name, f.application.Name)
+	return nil
+}
+
+func (f *Rocker) UnsetStagingEnv(writer io.Writer, name string) error {
+	if _, present := f.application.StagingEnv[name]; !present {
+		fmt.Fprintf(writer, "%s is not set for staging %s.\n", name, f.application.Name)
+		return nil
+	}
+	delete(f.application.StagingEnv, name)
+	if err := config.WriteStagingEnv(f.directories.AppHome(), f.application.StagingEnv); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Unset %s for staging %s. Use 'rock up' or 'rock stage' to pick up the change.\n", name, f.application.Name)
+	return nil
+}
+
+// SetStagingEnvGroup replaces the env vars every application is staged with, given as a JSON object
+func (f *Rocker) SetStagingEnvGroup(writer io.Writer, groupJSON string) error {
+	stagingEnvGroup, err := config.ParseStagingEnvGroup(groupJSON)
+	if err != nil {
+		return err
+	}
+	if err := config.WriteStagingEnvGroup(f.directories.Home(), stagingEnvGroup); err != nil {
+		return err
+	}
+	fmt.Fprintln(writer, "Set the staging env group. Applications pick it up when they are next staged.")
+	return nil
+}
+
+func (f *Rocker) PrintStagingEnvGroup(writer io.Writer) error {
+	stagingEnvGroup := config.Environment{}
+	stagingEnvGroup.SetAll(f.application.StagingEnvGroup, config.StagingGroupSource)
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "NAME\tVALUE")
+	for _, envVar := range stagingEnvGroup.List() {
+		fmt.Fprintf(tabWriter, "%s\t%s\n", envVar.Name, envVar.Value)
+	}
+	return tabWriter.Flush()
+}
+
+// A bound service instance that has since been deleted is skipped, so the application can still run
+func loadServiceBindings(directories *config.Directories, app string) ([]service.Binding, error) {
+	names, err := config.ReadBoundServices(directories.AppHome())
+	if err != nil {
+		return nil, err
+	}
+	bindings := []service.Binding{}
+	for _, name := range names {
+		instance, err := service.Load(directories.Services(), name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping bound service %s: %s\n", name, err)
+			continue
+		}
+		bindings = append(bindings, instance.BindingFor(app))
+	}
+	return bindings, nil
+}
+
+// BindService records a service instance as bound, to be put in VCAP_SERVICES from the next run onwards. A
+// brokered instance is bound through its broker, which gives the application credentials of its own
+func (f *Rocker) BindService(writer io.Writer, name string) error {
+	instance, err := service.Load(f.directories.Services(), name)
+	if err != nil {
+		return err
+	}
+	names, err := config.ReadBoundServices(f.directories.AppHome())
+	if err != nil {
+		return err
+	}
+	if containsName(names, name) {
+		fmt.Fprintf(writer, "%s is already bound to %s.\n", name, f.application.Name)
+		return nil
+	}
+	if instance.Brokered() {
+		if err := f.bindBrokeredService(instance); err != nil {
+			return err
+		}
+	}
+	if err := config.WriteBoundServices(f.directories.AppHome(), append(names, name)); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Bound %s to %s. Use 'rock up' or 'rock run' to pick up the change.\n", name, f.application.Name)
+	return nil
+}
+
+func (f *Rocker) UnbindService(writer io.Writer, name string) error {
+	names, err := config.ReadBoundServices(f.directories.AppHome())
+	if err != nil {
+		return err
+	}
+	if !containsName(names, name) {
+		fmt.Fprintf(writer, "%s is not bound to %s.\n", name, f.application.Name)
+		return nil
+	}
+	//an instance deleted since it was bound has nothing left to unbind from
+	if instance, err := service.Load(f.directories.Services(), name); err == nil && instance.Brokered() {
+		if err := f.unbindBrokeredService(instance, f.application.Slug()); err != nil {
+			return err
+		}
+	}
+	remaining := []string{}
+	for _, boundName := range names {
+		if boundName != name {
+			remaining = append(remaining, boundName)
+		}
+	}
+	if err := config.WriteBoundServices(f.directories.AppHome(), remaining); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Unbound %s from %s. Use 'rock up' or 'rock run' to pick up the change.\n", name, f.application.Name)
+	return nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, candidate := range names {
+		if candidate == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ClearCache throws away the build artifact cache, so the next staging downloads everything again
+func (f *Rocker) ClearCache(writer io.Writer) error {
+	size := cacheSize(f.directories)
+	for _, dir := range []string{f.directories.BuildArtifactsCache(), f.directories.OutputBuildArtifactsCache()} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(writer, "Cleared the build artifact cache for %s, freeing %s.\n", f.application.Name, bytefmt.ByteSize(uint64(size)))
+	return nil
+}
+
+// PrintInfo describes what Cloud Rocker keeps for the application
+func (f *Rocker) PrintInfo(writer io.Writer) error {
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tabWriter, "Application:\t%s\n", f.application.Name)
+	fmt.Fprintf(tabWriter, "Cloud Rocker directory:\t%s\n", f.directories.AppHome())
+	summary, err := config.ReadStagingSummary(f.directories.AppHome())
+	if err != nil {
+		return err
+	}
+	if summary == nil {
+		fmt.Fprintf(tabWriter, "Staging:\tnot staged yet\n")
+	} else {
+		writeStagingSummary(tabWriter, summary)
+	}
+	fmt.Fprintf(tabWriter, "Build artifact cache:\t%s\n", bytefmt.ByteSize(uint64(cacheSize(f.directories))))
+	return tabWriter.Flush()
+}
+
+func writeStagingSummary(writer io.Writer, summary *config.StagingSummary) {
+	fmt.Fprintf(writer, "Buildpack:\t%s\n", orNone(summary.BuildpackKey))
+	fmt.Fprintf(writer, "Buildpack version:\t%s\n", orNone(summary.BuildpackVersion))
+	fmt.Fprintf(writer, "Detected buildpack:\t%s\n", orNone(summary.DetectedBuildpack))
+	fmt.Fprintf(writer, "Process types:\t%s\n", orNone(strings.Join(summary.ProcessTypes, ", ")))
+	fmt.Fprintf(writer, "Start command:\t%s\n", orNone(summary.StartCommand))
+	fmt.Fprintf(writer, "Droplet size:\t%s\n", bytefmt.ByteSize(uint64(summary.DropletSize)))
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
 	}
+	return value
+}
+
+func cacheSize(directories *config.Directories) int64 {
+	dir := directories.BuildArtifactsCache()
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return 0
+	}
+	size, err := utils.DirSize(dir)
+	if err != nil {
+		log.Fatalf(" %s", err)
+	}
+	return size
+}
+
+func (f *Rocker) ExportDroplet(writer io.Writer, file string) error {
+	if err := droplet.Export(f.directories.Tmp()+"/droplet", file); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Exported the droplet for %s to %s.\n", f.application.Name, file)
+	return nil
+}
+
+// ImportDroplet replaces the application's droplet with one staged elsewhere - exported from Cloud Rocker, or
+// downloaded from Cloud Foundry - so it can be run or built into an image without restaging
+func (f *Rocker) ImportDroplet(writer io.Writer, file string) error {
+	if err := os.MkdirAll(f.directories.Tmp(), 0755); err != nil {
+		return err
+	}
+	if err := droplet.Import(file, f.directories.Tmp()+"/droplet"); err != nil {
+		return err
+	}
+	//the last staging's result describes a different droplet, and its hash gives way to the imported marker
+	if err := os.RemoveAll(f.directories.Tmp() + "/result.json"); err != nil {
+		return err
+	}
+	if err := stager.MarkImported(f.directories); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(f.directories.Droplet()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.directories.Droplet(), 0755); err != nil {
+		return err
+	}
+	summary, err := config.NewStagingSummary(f.directories.Tmp()+"/droplet", f.directories.Tmp()+"/result.json")
+	if err != nil {
+		return err
+	}
+	if err := config.WriteStagingSummary(f.directories.AppHome(), summary); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Imported the droplet for %s from %s - run it with 'rock run', or build an image with 'rock build'. Both use it until you stage with --force-stage.\n", f.application.Name, file)
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	writeStagingSummary(tabWriter, summary)
+	return tabWriter.Flush()
 }
 
 func DockerVersion(writer io.Writer) {
@@ -54,12 +388,12 @@ func DeleteContainer(writer io.Writer, name string) {
 	docker.DeleteContainer(client, writer, name)
 }
 
-func (f *Rocker) AddBuildpack(writer io.Writer, url string, buildpackDirOptional ...string) {
+func (f *Rocker) AddBuildpack(writer io.Writer, source string, options buildpack.AddOptions, buildpackDirOptional ...string) error {
 	buildpackDir := f.directories.Buildpacks()
 	if len(buildpackDirOptional) > 0 {
 		buildpackDir = buildpackDirOptional[0]
 	}
-	buildpack.Add(writer, url, abs(buildpackDir))
+	return buildpack.Add(writer, source, abs(buildpackDir), options)
 }
 
 func (f *Rocker) DeleteBuildpack(writer io.Writer, bpack string, buildpackDirOptional ...string) {
@@ -70,55 +404,718 @@ func (f *Rocker) DeleteBuildpack(writer io.Writer, bpack string, buildpackDirOpt
 	buildpack.Delete(writer, bpack, abs(buildpackDir))
 }
 
-func (f *Rocker) ListBuildpacks(writer io.Writer, buildpackDirOptional ...string) {
+// ListBuildpacks shows the installed buildpacks, verbosely with their versions, sources and when they were installed
+func (f *Rocker) ListBuildpacks(writer io.Writer, verbose bool, buildpackDirOptional ...string) error {
+	buildpackDir := f.directories.Buildpacks()
+	if len(buildpackDirOptional) > 0 {
+		buildpackDir = buildpackDirOptional[0]
+	}
+	if verbose {
+		return buildpack.ListVerbose(writer, abs(buildpackDir))
+	}
+	return buildpack.List(writer, abs(buildpackDir))
+}
+
+// UpdateBuildpack fetches a buildpack from where it was added from again, or every buildpack without a name
+func (f *Rocker) UpdateBuildpack(writer io.Writer, name string, buildpackDirOptional ...string) error {
+	buildpackDir := f.directories.Buildpacks()
+	if len(buildpackDirOptional) > 0 {
+		buildpackDir = buildpackDirOptional[0]
+	}
+	if name == "" {
+		return buildpack.UpdateAll(writer, abs(buildpackDir))
+	}
+	return buildpack.Update(writer, name, abs(buildpackDir))
+}
+
+func (f *Rocker) SetBuildpackOrder(writer io.Writer, buildpacks []string, buildpackDirOptional ...string) error {
 	buildpackDir := f.directories.Buildpacks()
 	if len(buildpackDirOptional) > 0 {
 		buildpackDir = buildpackDirOptional[0]
 	}
-	buildpack.List(writer, abs(buildpackDir))
+	return buildpack.SetDetectOrder(writer, buildpacks, abs(buildpackDir))
 }
 
+// CreateService runs the built in service types in local containers, and provisions any other service from a registered broker
+func (f *Rocker) CreateService(writer io.Writer, serviceType string, name string, planOptional ...string) error {
+	var plan string
+	if len(planOptional) > 0 {
+		plan = planOptional[0]
+	}
+	if err := service.ValidateName(name); err != nil {
+		return err
+	}
+	if service.Exists(f.directories.Services(), name) {
+		return fmt.Errorf("Service %s already exists", name)
+	}
+	typeInfo, err := service.LookupType(serviceType)
+	if err != nil {
+		serviceBroker, offering, found, brokerErr := f.findBrokeredService(serviceType)
+		if brokerErr != nil {
+			return brokerErr
+		}
+		if !found {
+			return fmt.Errorf("%s, or a service from 'rock marketplace'", err)
+		}
+		return f.createBrokeredService(writer, serviceBroker, offering, plan, name)
+	}
+	if plan != "" && plan != service.LocalPlan {
+		return fmt.Errorf("Service %s only has the plan %s", serviceType, service.LocalPlan)
+	}
+	hostPort, err := utils.FreePort()
+	if err != nil {
+		return err
+	}
+	password, err := service.NewPassword()
+	if err != nil {
+		return err
+	}
+	containerConfig := config.NewServiceContainerConfig(service.ContainerName(name), typeInfo.Image, typeInfo.Port, hostPort,
+		typeInfo.Env(password), typeInfo.Command(password))
+	client := docker.GetNewClient()
+	if docker.GetContainerID(client, containerConfig.ContainerName) != "" {
+		return fmt.Errorf("A container called %s already exists - remove it with 'docker rm -f %s'", containerConfig.ContainerName, containerConfig.ContainerName)
+	}
+	fmt.Fprintf(writer, "Creating %s service %s from the local %s image...\n", serviceType, name, typeInfo.Image)
+	docker.RunServiceContainer(client, writer, containerConfig)
+	instance := typeInfo.NewInstance(name, name, hostPort, password)
+	if err := service.Save(f.directories.Services(), instance); err != nil {
+		return err
+	}
+	bindingJSON, err := json.MarshalIndent(instance.Binding, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Created service %s. Connect to it from your machine on localhost:%d\n", name, hostPort)
+	fmt.Fprintf(writer, "Applications see it in VCAP_SERVICES as:\n%s\n", bindingJSON)
+	return nil
+}
+
+// findBrokeredService looks for a service offering in the catalogs of the registered brokers
+func (f *Rocker) findBrokeredService(serviceType string) (*broker.Broker, broker.Service, bool, error) {
+	brokers, err := broker.List(f.directories.Brokers())
+	if err != nil {
+		return nil, broker.Service{}, false, err
+	}
+	for _, serviceBroker := range brokers {
+		catalog, err := serviceBroker.Client().Catalog()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping service broker %s: %s\n", serviceBroker.Name, err)
+			continue
+		}
+		if offering, found := catalog.Service(serviceType); found {
+			return serviceBroker, offering, true, nil
+		}
+	}
+	return nil, broker.Service{}, false, nil
+}
+
+// A brokered instance is only provisioned when it is created - each application it is bound to gets a binding,
+// and credentials, of its own
+func (f *Rocker) createBrokeredService(writer io.Writer, serviceBroker *broker.Broker, offering broker.Service, planName string, name string) error {
+	plan, err := offering.Plan(planName)
+	if err != nil {
+		return err
+	}
+	instanceID, err := broker.NewGUID()
+	if err != nil {
+		return err
+	}
+	brokered := &service.BrokeredInstance{
+		Broker:     serviceBroker.Name,
+		ServiceID:  offering.ID,
+		PlanID:     plan.ID,
+		InstanceID: instanceID,
+		Bindable:   offering.Bindable,
+	}
+	fmt.Fprintf(writer, "Creating %s service %s with plan %s from service broker %s...\n", offering.Name, name, plan.Name, serviceBroker.Name)
+	if err := serviceBroker.Client().Provision(brokered.InstanceID, brokered.ServiceID, brokered.PlanID, nil); err != nil {
+		return err
+	}
+	tags := offering.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	instance := &service.Instance{
+		Binding: service.Binding{
+			Name:        name,
+			Label:       offering.Name,
+			Tags:        tags,
+			Plan:        plan.Name,
+			Credentials: map[string]interface{}{},
+		},
+		Broker: brokered,
+	}
+	if err := service.Save(f.directories.Services(), instance); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Created service %s. Bind it with 'rock bind-service %s'.\n", name, name)
+	return nil
+}
+
+func (f *Rocker) bindBrokeredService(instance *service.Instance) error {
+	brokered := instance.Broker
+	if !brokered.Bindable {
+		return fmt.Errorf("Service %s can't be bound - its broker doesn't offer bindings", instance.Name)
+	}
+	serviceBroker, err := broker.Load(f.directories.Brokers(), brokered.Broker)
+	if err != nil {
+		return err
+	}
+	bindingID, err := broker.NewGUID()
+	if err != nil {
+		return err
+	}
+	binding, err := serviceBroker.Client().Bind(brokered.InstanceID, bindingID, brokered.ServiceID, brokered.PlanID)
+	if err != nil {
+		return err
+	}
+	if brokered.Bindings == nil {
+		brokered.Bindings = map[string]service.BrokeredBinding{}
+	}
+	brokered.Bindings[f.application.Slug()] = service.BrokeredBinding{
+		BindingID:      bindingID,
+		Credentials:    binding.Credentials,
+		SyslogDrainURL: binding.SyslogDrainURL,
+	}
+	return service.Save(f.directories.Services(), instance)
+}
+
+func (f *Rocker) unbindBrokeredService(instance *service.Instance, app string) error {
+	brokered := instance.Broker
+	binding, present := brokered.Bindings[app]
+	if !present {
+		return nil
+	}
+	serviceBroker, err := broker.Load(f.directories.Brokers(), brokered.Broker)
+	if err != nil {
+		return err
+	}
+	if err := serviceBroker.Client().Unbind(brokered.InstanceID, binding.BindingID, brokered.ServiceID, brokered.PlanID); err != nil {
+		return err
+	}
+	delete(brokered.Bindings, app)
+	return service.Save(f.directories.Services(), instance)
+}
+
+// Any binding left behind, such as by an application whose Cloud Rocker directory was removed, is deleted before
+// the instance is deprovisioned
+func (f *Rocker) deleteBrokeredService(instance *service.Instance) error {
+	for app := range instance.Broker.Bindings {
+		if err := f.unbindBrokeredService(instance, app); err != nil {
+			return err
+		}
+	}
+	brokered := instance.Broker
+	serviceBroker, err := broker.Load(f.directories.Brokers(), brokered.Broker)
+	if err != nil {
+		return err
+	}
+	return serviceBroker.Client().Deprovision(brokered.InstanceID, brokered.ServiceID, brokered.PlanID)
+}
+
+// AddServiceBroker registers a broker whose services can then be created with create-service
+func (f *Rocker) AddServiceBroker(writer io.Writer, name string, url string, username string, password string) error {
+	if err := service.ValidateName(name); err != nil {
+		return err
+	}
+	//instances provisioned through a broker are bound and deprovisioned through whatever is registered under its name
+	if broker.Exists(f.directories.Brokers(), name) {
+		return fmt.Errorf("Service broker %s already exists - delete it with 'rock delete-service-broker %s' first", name, name)
+	}
+	serviceBroker := &broker.Broker{Name: name, URL: url, Username: username, Password: password}
+	catalog, err := serviceBroker.Client().Catalog()
+	if err != nil {
+		return err
+	}
+	if err := broker.Save(f.directories.Brokers(), serviceBroker); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Added service broker %s with %d services. See them with 'rock marketplace'.\n", name, len(catalog.Services))
+	return nil
+}
+
+// DeleteServiceBroker forgets a registered broker, once none of the service instances it provides are left
+func (f *Rocker) DeleteServiceBroker(writer io.Writer, name string) error {
+	if _, err := broker.Load(f.directories.Brokers(), name); err != nil {
+		return err
+	}
+	instances, err := service.List(f.directories.Services())
+	if err != nil {
+		return err
+	}
+	provided := []string{}
+	for _, instance := range instances {
+		if instance.Brokered() && instance.Broker.Broker == name {
+			provided = append(provided, instance.Name)
+		}
+	}
+	if len(provided) > 0 {
+		return fmt.Errorf("Service broker %s still provides %s - delete them first", name, strings.Join(provided, ", "))
+	}
+	if err := broker.Delete(f.directories.Brokers(), name); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Deleted service broker %s.\n", name)
+	return nil
+}
+
+// Marketplace lists the built in service types and the services offered by each registered broker
+func (f *Rocker) Marketplace(writer io.Writer) error {
+	brokers, err := broker.List(f.directories.Brokers())
+	if err != nil {
+		return err
+	}
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "SERVICE\tPLANS\tBROKER\tDESCRIPTION")
+	for _, label := range service.TypeLabels() {
+		typeInfo, _ := service.LookupType(label)
+		fmt.Fprintf(tabWriter, "%s\t%s\t-\tlocal container from the %s image\n", label, service.LocalPlan, typeInfo.Image)
+	}
+	for _, serviceBroker := range brokers {
+		catalog, err := serviceBroker.Client().Catalog()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping service broker %s: %s\n", serviceBroker.Name, err)
+			continue
+		}
+		for _, offering := range catalog.Services {
+			plans := []string{}
+			for _, plan := range offering.Plans {
+				plans = append(plans, plan.Name)
+			}
+			fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\n", offering.Name, strings.Join(plans, ", "), serviceBroker.Name, offering.Description)
+		}
+	}
+	return tabWriter.Flush()
+}
+
+// CreateUserProvidedService records credentials for a service running outside Cloud Rocker
+func (f *Rocker) CreateUserProvidedService(writer io.Writer, name string, credentials string, syslogDrainURL string) error {
+	if err := service.ValidateName(name); err != nil {
+		return err
+	}
+	if service.Exists(f.directories.Services(), name) {
+		return fmt.Errorf("Service %s already exists", name)
+	}
+	parsedCredentials, err := service.ParseCredentials(credentials)
+	if err != nil {
+		return err
+	}
+	instance := service.NewUserProvidedInstance(name, parsedCredentials, syslogDrainURL)
+	if err := service.Save(f.directories.Services(), instance); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Created user-provided service %s. Bind it with 'rock bind-service %s'.\n", name, name)
+	return nil
+}
+
+func (f *Rocker) ListServices(writer io.Writer) error {
+	instances, err := service.List(f.directories.Services())
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		fmt.Fprintln(writer, "No services created")
+		return nil
+	}
+	client := docker.GetNewClient()
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "NAME\tSERVICE\tPLAN\tSTATUS\tHOST PORT")
+	for _, instance := range instances {
+		if instance.UserProvided() {
+			fmt.Fprintf(tabWriter, "%s\t%s\t\t-\t-\n", instance.Name, instance.Label)
+			continue
+		}
+		if instance.Brokered() {
+			fmt.Fprintf(tabWriter, "%s\t%s\t%s\tvia %s\t-\n", instance.Name, instance.Label, instance.Plan, instance.Broker.Broker)
+			continue
+		}
+		status := "stopped"
+		if docker.ContainerRunning(client, instance.ContainerName) {
+			status = "running"
+		}
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%d\n", instance.Name, instance.Label, instance.Plan, status, instance.HostPort)
+	}
+	return tabWriter.Flush()
+}
+
+func (f *Rocker) DeleteService(writer io.Writer, name string) error {
+	instance, err := service.Load(f.directories.Services(), name)
+	if err != nil {
+		return err
+	}
+	apps, err := config.AppsBoundTo(f.directories.Home(), name)
+	if err != nil {
+		return err
+	}
+	if len(apps) > 0 {
+		return fmt.Errorf("Service %s is still bound to %s - unbind it first", name, strings.Join(apps, ", "))
+	}
+	if instance.Brokered() {
+		if err := f.deleteBrokeredService(instance); err != nil {
+			return err
+		}
+	} else if !instance.UserProvided() {
+		client := docker.GetNewClient()
+		if docker.GetContainerID(client, instance.ContainerName) != "" {
+			DeleteContainer(writer, instance.ContainerName)
+		}
+	}
+	if err := service.Delete(f.directories.Services(), name); err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "Deleted service %s.\n", name)
+	return nil
+}
+
+// A failed staging reports this many of the last lines of its output
+const stagingOutputTailLines = 20
+
+// RunStager stages the application, unless its droplet was staged from the same application files, buildpacks,
+// stack and staging environment, or was imported, in which case the droplet is reused
 func (f *Rocker) RunStager(writer io.Writer) error {
+	//an imported droplet needs none of the buildpacks that would stage the application here
+	if !f.forceStage && stager.Imported(f.directories) {
+		fmt.Fprintln(writer, "Using the imported droplet. Use --force-stage to stage the application instead.")
+		return f.printStagingSummary(writer)
+	}
+	if err := buildpack.AtLeastOneBuildpackIn(f.directories.Buildpacks()); err != nil {
+		return err
+	}
+	for _, chosen := range f.application.ChosenBuildpacks() {
+		if !buildpack.IsURL(chosen) && !buildpack.Installed(chosen, f.directories.Buildpacks()) {
+			return fmt.Errorf("Buildpack %s is not installed - see 'rock buildpacks'", chosen)
+		}
+	}
+	stagingHash, err := stager.StagingHash(f.application, f.directories.Buildpacks())
+	if err != nil {
+		return err
+	}
+	if !f.forceStage && stager.StagedWith(f.directories, stagingHash) {
+		return f.reuseDroplet(writer)
+	}
+	if err := stager.ForgetStagingHash(f.directories); err != nil {
+		return err
+	}
 	prepareStagingFilesystem(f.directories)
-	prepareStagingApp(f.directories.App(), f.directories.Staging())
-	containerConfig := config.NewStageContainerConfig(f.directories)
+	prepareStagingApp(writer, f.application.Path, f.directories.Staging())
+	containerConfig := config.NewStageContainerConfig(f.directories, f.application)
 	client := docker.GetNewClient()
-	docker.RunStagingContainer(client, writer, containerConfig)
+	outputTail := stager.NewOutputTail(writer, stagingOutputTailLines)
+	exitCode, err := docker.RunStagingContainer(client, outputTail, containerConfig)
 	DeleteContainer(writer, containerConfig.ContainerName)
-	return stager.ValidateStagedApp(f.directories)
+	if err != nil {
+		return err
+	}
+	if err := stager.RemoveOutputBuildArtifactsCache(f.directories); err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return stager.StagingFailure(exitCode, outputTail.Lines())
+	}
+	if err := stager.ValidateStagedApp(f.directories); err != nil {
+		return err
+	}
+	if err := f.validateStartCommand(); err != nil {
+		return err
+	}
+	if err := stager.WriteStagingHash(f.directories, stagingHash); err != nil {
+		return err
+	}
+	return f.summariseStaging(writer)
 }
 
-func (f *Rocker) StageApp(writer io.Writer, buildpackDirOptional ...string) error {
+func (f *Rocker) reuseDroplet(writer io.Writer) error {
+	fmt.Fprintln(writer, "Nothing has changed since the application was staged - reusing its droplet. Use --force-stage to stage it again.")
+	return f.printStagingSummary(writer)
+}
+
+func (f *Rocker) printStagingSummary(writer io.Writer) error {
+	summary, err := config.ReadStagingSummary(f.directories.AppHome())
+	if err != nil || summary == nil {
+		return err
+	}
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	writeStagingSummary(tabWriter, summary)
+	return tabWriter.Flush()
+}
+
+// The summary is kept with the application, so rock info can show it again later
+func (f *Rocker) summariseStaging(writer io.Writer) error {
+	summary, err := config.NewStagingSummary(f.directories.Tmp()+"/droplet", f.directories.Tmp()+"/result.json")
+	if err != nil {
+		return err
+	}
+	//buildpack URLs are fetched afresh by each staging, so only installed buildpacks have a known version
+	if buildpack.Installed(summary.BuildpackKey, f.directories.Buildpacks()) {
+		metadata, err := buildpack.ReadMetadata(summary.BuildpackKey, f.directories.Buildpacks())
+		if err != nil {
+			return err
+		}
+		if metadata.Version != "" || metadata.Commit != "" {
+			summary.BuildpackVersion = metadata.Describe()
+		}
+	}
+	if err := config.WriteStagingSummary(f.directories.AppHome(), summary); err != nil {
+		return err
+	}
+	fmt.Fprintln(writer, "Staged the application.")
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	writeStagingSummary(tabWriter, summary)
+	return tabWriter.Flush()
+}
+
+// A staged application that has nothing to start fails now, rather than when it is run
+func (f *Rocker) validateStartCommand() error {
+	detectedStartCommand, err := config.ReadDetectedStartCommand(f.directories.Tmp() + "/result.json")
+	if err != nil {
+		return err
+	}
+	procfile, _ := config.ParseProcfile(f.directories.Staging() + "/Procfile")
+	command, err := config.StartCommand(f.application, config.WebProcess, procfile, detectedStartCommand)
+	if err != nil {
+		return fmt.Errorf("Staging failed - %s", err)
+	}
+	if _, err := config.SplitCommand(command); err != nil {
+		return fmt.Errorf("Staging failed - %s", err)
+	}
+	return nil
+}
+
+func (f *Rocker) StageApp(writer io.Writer, buildpacks []string, buildpackDirOptional ...string) error {
 	buildpackDir := f.directories.ContainerBuildpacks()
 	if len(buildpackDirOptional) > 0 {
 		buildpackDir = buildpackDirOptional[0]
 	}
-	buildpackRunner := stager.NewBuildpackRunner(abs(buildpackDir))
+	buildpackRunner := stager.NewBuildpackRunner(abs(buildpackDir), f.directories.ContainerDeps(), buildpacks)
 	err := stager.RunBuildpack(writer, buildpackRunner)
 	return err
 }
 
-func (f *Rocker) RunRuntime(writer io.Writer) {
+// The host port can be a number, "auto" to pick free ports, or left out to reuse the application's last ports
+func (f *Rocker) RunRuntime(writer io.Writer, hostPortOptional ...string) {
 	prepareRuntimeFilesystem(f.directories)
-	containerConfig := config.NewRuntimeContainerConfig(f.directories.Droplet())
+	if err := checkDiskQuota(f.directories.Droplet()+"/app", f.application.DiskQuota); err != nil {
+		log.Fatalf(" %s", err)
+	}
 	client := docker.GetNewClient()
-	if docker.GetContainerID(client, containerConfig.ContainerName) != "" {
+	if docker.GetContainerID(client, f.application.ProcessContainerName(f.process, 0)) != "" {
 		fmt.Println("Deleting running runtime container...")
-		f.StopRuntime(writer)
+		f.stopProcess(writer, f.process)
 	}
-	client = docker.GetNewClient()
+	f.application.ServiceLinks = f.serviceLinks(client)
+	if f.process != config.WebProcess {
+		f.runProcess(writer)
+		return
+	}
+	requestedHostPort := ""
+	if len(hostPortOptional) > 0 {
+		requestedHostPort = hostPortOptional[0]
+	}
+	hostPorts, err := f.chooseHostPorts(requestedHostPort, true)
+	if err != nil {
+		log.Fatalf(" %s", err)
+	}
+	if err := config.WriteHostPorts(f.directories.AppHome(), hostPorts); err != nil {
+		log.Fatalf(" %s", err)
+	}
+	for index, hostPort := range hostPorts {
+		instance := newInstance(index, hostPort, requestedHostPort)
+		containerConfig := config.NewRuntimeContainerConfig(f.directories.Droplet(), f.application, instance)
+		client = docker.GetNewClient()
+		docker.RunRuntimeContainer(client, writer, containerConfig)
+		fmt.Fprintf(writer, "Connect to your running application at http://localhost:%d/\n", hostPort)
+	}
+}
+
+// Every running service container is linked into the application's containers as the service's name, so
+// credentials - bound or in vcap_services.json - can use that name as the hostname
+func (f *Rocker) serviceLinks(client docker.DockerClient) map[string]string {
+	links := map[string]string{}
+	instances, err := service.List(f.directories.Services())
+	if err != nil {
+		log.Fatalf(" %s", err)
+	}
+	for _, instance := range instances {
+		if instance.ContainerName != "" && docker.ContainerRunning(client, instance.ContainerName) {
+			links[instance.ContainerName] = instance.Name
+		}
+	}
+	return links
+}
+
+// Process types other than web run a single, unpublished instance from the same droplet
+func (f *Rocker) runProcess(writer io.Writer) {
+	instance := config.Instance{Index: 0, Process: f.process}
+	containerConfig := config.NewRuntimeContainerConfig(f.directories.Droplet(), f.application, instance)
+	client := docker.GetNewClient()
 	docker.RunRuntimeContainer(client, writer, containerConfig)
-	fmt.Fprintln(writer, "Connect to your running application at http://localhost:8080/")
+	fmt.Fprintf(writer, "Started the %s process of %s\n", f.process, f.application.Name)
+}
+
+func newInstance(index int, hostPort int, requestedHostPort string) config.Instance {
+	instance := config.Instance{Index: index, HostPort: hostPort}
+	if requestedHostPort != "" {
+		instance.HostPortSource = config.FlagSource
+	}
+	return instance
+}
+
+// PrintEnv shows the environment of the staging container and the first runtime instance, and where each value came from
+func (f *Rocker) PrintEnv(writer io.Writer, jsonOutput bool, hostPortOptional ...string) error {
+	requestedHostPort := ""
+	if len(hostPortOptional) > 0 {
+		requestedHostPort = hostPortOptional[0]
+	}
+	hostPorts, err := f.chooseHostPorts(requestedHostPort, false)
+	if err != nil {
+		return err
+	}
+	instance := newInstance(0, hostPorts[0], requestedHostPort)
+	stagingEnv := config.StagingEnvironment(f.application).List()
+	runtimeEnv := config.RuntimeEnvironment(f.directories.Droplet(), f.application, instance).List()
+	if jsonOutput {
+		return json.NewEncoder(writer).Encode(map[string][]config.EnvVar{
+			"staging": stagingEnv,
+			"runtime": runtimeEnv,
+		})
+	}
+	printEnvVars(writer, "Staging", stagingEnv)
+	fmt.Fprintln(writer)
+	printEnvVars(writer, "Runtime", runtimeEnv)
+	return nil
+}
+
+func printEnvVars(writer io.Writer, phase string, envVars []config.EnvVar) {
+	fmt.Fprintf(writer, "%s environment:\n", phase)
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "NAME\tSOURCE\tVALUE")
+	for _, envVar := range envVars {
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\n", envVar.Name, envVar.Source, envVar.Value)
+	}
+	tabWriter.Flush()
+}
+
+func (f *Rocker) chooseHostPorts(requestedHostPort string, checkAvailable bool) ([]int, error) {
+	recordedHostPorts, err := config.ReadHostPorts(f.directories.AppHome())
+	if err != nil {
+		return nil, err
+	}
+	hostPorts := []int{}
+	for index := 0; index < f.application.Instances; index++ {
+		var hostPort int
+		switch {
+		case requestedHostPort == "auto":
+			for hostPort == 0 || containsPort(hostPorts, hostPort) {
+				if hostPort, err = utils.FreePort(); err != nil {
+					return nil, err
+				}
+			}
+		case requestedHostPort != "":
+			basePort, err := strconv.Atoi(requestedHostPort)
+			if err != nil || basePort <= 0 {
+				return nil, fmt.Errorf("Invalid port %s - please supply a port number or 'auto'", requestedHostPort)
+			}
+			hostPort = basePort + index
+		case index < len(recordedHostPorts):
+			hostPort = recordedHostPorts[index]
+		default:
+			hostPort = config.DefaultHostPort + index
+		}
+		if checkAvailable && !utils.PortAvailable(hostPort) {
+			return nil, fmt.Errorf("Port %d is already in use - choose another with --port, or use --port auto", hostPort)
+		}
+		hostPorts = append(hostPorts, hostPort)
+	}
+	return hostPorts, nil
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, existingPort := range ports {
+		if existingPort == port {
+			return true
+		}
+	}
+	return false
 }
 
+// StopRuntime stops the web instances and any other processes from the application's Procfile
 func (f *Rocker) StopRuntime(writer io.Writer) {
-	StopContainer(writer, "cloudrocker-runtime")
-	DeleteContainer(writer, "cloudrocker-runtime")
+	f.stopProcess(writer, config.WebProcess)
+	for _, process := range config.ProcessTypes(f.directories.Droplet()) {
+		if process != config.WebProcess {
+			f.stopProcess(writer, process)
+		}
+	}
+}
+
+func (f *Rocker) stopProcess(writer io.Writer, process string) {
+	client := docker.GetNewClient()
+	for index := 0; docker.GetContainerID(client, f.application.ProcessContainerName(process, index)) != ""; index++ {
+		containerName := f.application.ProcessContainerName(process, index)
+		if docker.ContainerOOMKilled(client, containerName) {
+			fmt.Fprintf(writer, "Instance %d of the %s process of %s was killed because it ran out of memory - raise its memory limit with --memory or in manifest.yml\n", index, process, f.application.Name)
+		}
+		//a container that has already exited, such as one killed for running out of memory, only needs deleting
+		if docker.ContainerRunning(client, containerName) {
+			StopContainer(writer, containerName)
+		}
+		DeleteContainer(writer, containerName)
+	}
+}
+
+// PrintInstances shows whether each of the application's containers is running, or how it stopped
+func (f *Rocker) PrintInstances(writer io.Writer) error {
+	client := docker.GetNewClient()
+	tabWriter := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	processes := []string{config.WebProcess}
+	for _, process := range config.ProcessTypes(f.directories.Droplet()) {
+		if process != config.WebProcess {
+			processes = append(processes, process)
+		}
+	}
+	found := false
+	for _, process := range processes {
+		for index := 0; ; index++ {
+			state := docker.ContainerState(client, f.application.ProcessContainerName(process, index))
+			if state == "" {
+				break
+			}
+			found = true
+			fmt.Fprintf(tabWriter, "Instance %d of %s:\t%s\n", index, process, state)
+		}
+	}
+	if !found {
+		fmt.Fprintf(tabWriter, "Instances:\tnot running - start them with 'rock up' or 'rock run'\n")
+	}
+	return tabWriter.Flush()
+}
+
+func checkDiskQuota(appDir string, diskQuota string) error {
+	if diskQuota == "" {
+		return nil
+	}
+	quota, err := bytefmt.ToMegabytes(diskQuota)
+	if err != nil {
+		return fmt.Errorf("Invalid disk_quota %s: %s", diskQuota, err)
+	}
+	size, err := utils.DirSize(appDir)
+	if err != nil {
+		return err
+	}
+	if uint64(size) > quota*bytefmt.MEGABYTE {
+		return fmt.Errorf("The staged application is %s, which exceeds its disk_quota of %s", bytefmt.ByteSize(uint64(size)), diskQuota)
+	}
+	return nil
 }
 
 func (f *Rocker) BuildRuntimeImage(writer io.Writer, destImageTagOptional ...string) {
 	prepareRuntimeFilesystem(f.directories)
-	containerConfig := config.NewRuntimeContainerConfig(f.directories.Droplet(), destImageTagOptional...)
+	instance := config.Instance{Index: 0, HostPort: config.DefaultHostPort, Process: f.process}
+	if f.process != config.WebProcess {
+		instance.HostPort = 0
+	}
+	containerConfig := config.NewRuntimeContainerConfig(f.directories.Droplet(), f.application, instance, destImageTagOptional...)
 	client := docker.GetNewClient()
 	docker.BuildRuntimeImage(client, writer, containerConfig)
 }
@@ -136,24 +1133,28 @@ func prepareStagingFilesystem(directories *config.Directories) {
 	if err := CreateAndCleanAppDirs(directories); err != nil {
 		log.Fatalf(" %s", err)
 	}
-	if err := buildpack.AtLeastOneBuildpackIn(directories.Buildpacks()); err != nil {
-		log.Fatalf(" %s", err)
-	}
 	if err := utils.CopyRockerBinaryToDir(directories.Rocker()); err != nil {
 		log.Fatalf(" %s", err)
 	}
 }
 
-func prepareStagingApp(appDir string, stagingDir string) {
-	copyDir(appDir, stagingDir)
-}
-
-func copyDir(src string, dest string) {
-	src = src + "/*"
-	command := "shopt -s dotglob && cp -ra " + src + " " + dest
-	if err := exec.Command("bash", "-c", command).Run(); err != nil {
-		log.Fatalf("error copying from %s to %s : %s", src, dest, err)
+func prepareStagingApp(writer io.Writer, appPath string, stagingDir string) {
+	appPathInfo, err := os.Stat(appPath)
+	if err != nil {
+		log.Fatalf(" %s", err)
+	}
+	if !appPathInfo.IsDir() {
+		//a manifest path can point at an archive, such as a jar, as with cf push
+		if err := extractor.NewZip().Extract(appPath, stagingDir); err != nil {
+			log.Fatalf("error extracting %s to %s : %s", appPath, stagingDir, err)
+		}
+		return
+	}
+	report, err := appfiles.Copy(appPath, stagingDir)
+	if err != nil {
+		log.Fatalf("error copying from %s to %s : %s", appPath, stagingDir, err)
 	}
+	fmt.Fprintf(writer, "Uploaded %d files (%s), leaving out those in .cfignore.\n", report.Files, bytefmt.ByteSize(uint64(report.Bytes)))
 }
 
 func prepareRuntimeFilesystem(directories *config.Directories) {
@@ -170,6 +1171,14 @@ func prepareRuntimeFilesystem(directories *config.Directories) {
 	if err := utils.AddLauncherRunScript(directories.Droplet() + "/app"); err != nil {
 		log.Fatalf(" %s", err)
 	}
+
+	//keep the staging result with the droplet it describes - imported droplets don't have one
+	if _, err := os.Stat(directories.Tmp() + "/result.json"); os.IsNotExist(err) {
+		return
+	}
+	if err := utils.Cp(directories.Tmp()+"/result.json", directories.Droplet()+"/result.json"); err != nil {
+		log.Fatalf(" %s", err)
+	}
 }
 
 func abs(relative string) string {
@@ -5,7 +5,7 @@ class CloudCredo < Sinatra::Base
   configure do
     redis_service = JSON.parse(ENV['VCAP_SERVICES'])["redis"]
     credentials = redis_service.first["credentials"]
-    $redis = Redis.new(:host => credentials["hostname"], :port => credentials["port"])
+    $redis = Redis.new(:host => credentials["hostname"], :port => credentials["port"], :password => credentials["password"])
   end
 
   get '/' do
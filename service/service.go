@@ -0,0 +1,343 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	username = "cloudrocker"
+	database = "cloudrocker"
+
+	// LocalPlan is the only plan of the service types run in local containers
+	LocalPlan = "local"
+
+	// UserProvidedLabel is the VCAP_SERVICES key Cloud Foundry puts user-provided service instances under
+	UserProvidedLabel = "user-provided"
+)
+
+// Binding is a service instance as an application sees it in VCAP_SERVICES
+type Binding struct {
+	Name           string                 `json:"name"`
+	Label          string                 `json:"label"`
+	Tags           []string               `json:"tags"`
+	Plan           string                 `json:"plan,omitempty"`
+	Credentials    map[string]interface{} `json:"credentials"`
+	SyslogDrainURL string                 `json:"syslog_drain_url,omitempty"`
+}
+
+// Instance is a service instance as recorded under CLOUDROCKER_HOME, along with the container or broker that provides it
+type Instance struct {
+	Binding
+	ContainerName string            `json:"container_name,omitempty"`
+	HostPort      int               `json:"host_port,omitempty"`
+	Broker        *BrokeredInstance `json:"broker,omitempty"`
+}
+
+// BrokeredInstance identifies an instance provisioned by a service broker, so it can be bound, unbound and
+// deprovisioned, and keeps the binding the broker made for each application it is bound to
+type BrokeredInstance struct {
+	Broker     string                     `json:"broker"`
+	ServiceID  string                     `json:"service_id"`
+	PlanID     string                     `json:"plan_id"`
+	InstanceID string                     `json:"instance_id"`
+	Bindable   bool                       `json:"bindable"`
+	Bindings   map[string]BrokeredBinding `json:"bindings,omitempty"`
+}
+
+// BrokeredBinding is one application's binding to a brokered instance, with the credentials the broker gave it
+type BrokeredBinding struct {
+	BindingID      string                 `json:"binding_id"`
+	Credentials    map[string]interface{} `json:"credentials"`
+	SyslogDrainURL string                 `json:"syslog_drain_url,omitempty"`
+}
+
+// Type describes how to run a kind of service from a local Docker image, and the credentials it is bound with
+type Type struct {
+	Label       string
+	Image       string
+	Port        int
+	Tags        []string
+	env         func(password string) map[string]string
+	command     func(password string) []string
+	credentials func(host string, port int, password string) map[string]interface{}
+}
+
+var types = map[string]Type{
+	"redis": {
+		Label: "redis",
+		Image: "redis:latest",
+		Port:  6379,
+		Tags:  []string{"redis", "key-value"},
+		command: func(password string) []string {
+			return []string{"redis-server", "--requirepass", password}
+		},
+		credentials: func(host string, port int, password string) map[string]interface{} {
+			return map[string]interface{}{
+				"host":     host,
+				"hostname": host,
+				"port":     port,
+				"password": password,
+				"uri":      fmt.Sprintf("redis://:%s@%s:%d", password, host, port),
+			}
+		},
+	},
+	"postgres": {
+		Label: "postgres",
+		Image: "postgres:latest",
+		Port:  5432,
+		Tags:  []string{"postgres", "postgresql", "relational"},
+		env: func(password string) map[string]string {
+			return map[string]string{
+				"POSTGRES_USER":     username,
+				"POSTGRES_PASSWORD": password,
+				"POSTGRES_DB":       database,
+			}
+		},
+		credentials: func(host string, port int, password string) map[string]interface{} {
+			return databaseCredentials("postgres", "postgresql", host, port, password)
+		},
+	},
+	"mysql": {
+		Label: "mysql",
+		Image: "mysql:latest",
+		Port:  3306,
+		Tags:  []string{"mysql", "relational"},
+		env: func(password string) map[string]string {
+			return map[string]string{
+				"MYSQL_ROOT_PASSWORD": password,
+				"MYSQL_USER":          username,
+				"MYSQL_PASSWORD":      password,
+				"MYSQL_DATABASE":      database,
+			}
+		},
+		credentials: func(host string, port int, password string) map[string]interface{} {
+			return databaseCredentials("mysql", "mysql", host, port, password)
+		},
+	},
+	"rabbitmq": {
+		Label: "rabbitmq",
+		Image: "rabbitmq:latest",
+		Port:  5672,
+		Tags:  []string{"rabbitmq", "amqp", "messaging"},
+		env: func(password string) map[string]string {
+			return map[string]string{
+				"RABBITMQ_DEFAULT_USER": username,
+				"RABBITMQ_DEFAULT_PASS": password,
+			}
+		},
+		credentials: func(host string, port int, password string) map[string]interface{} {
+			return map[string]interface{}{
+				"host":     host,
+				"hostname": host,
+				"port":     port,
+				"username": username,
+				"password": password,
+				"vhost":    "/",
+				"uri":      fmt.Sprintf("amqp://%s:%s@%s:%d/%%2F", username, password, host, port),
+			}
+		},
+	},
+}
+
+func databaseCredentials(scheme string, jdbcScheme string, host string, port int, password string) map[string]interface{} {
+	return map[string]interface{}{
+		"host":     host,
+		"hostname": host,
+		"port":     port,
+		"name":     database,
+		"username": username,
+		"password": password,
+		"uri":      fmt.Sprintf("%s://%s:%s@%s:%d/%s", scheme, username, password, host, port, database),
+		"jdbcUrl":  fmt.Sprintf("jdbc:%s://%s:%d/%s?user=%s&password=%s", jdbcScheme, host, port, database, username, password),
+	}
+}
+
+func LookupType(label string) (Type, error) {
+	serviceType, present := types[label]
+	if !present {
+		return Type{}, fmt.Errorf("Unknown service type %s - choose one of %s", label, strings.Join(TypeLabels(), ", "))
+	}
+	return serviceType, nil
+}
+
+func TypeLabels() []string {
+	labels := []string{}
+	for label := range types {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func (serviceType Type) Env(password string) map[string]string {
+	if serviceType.env == nil {
+		return map[string]string{}
+	}
+	return serviceType.env(password)
+}
+
+func (serviceType Type) Command(password string) []string {
+	if serviceType.command == nil {
+		return []string{}
+	}
+	return serviceType.command(password)
+}
+
+// NewInstance describes a service instance whose container is reachable on host
+func (serviceType Type) NewInstance(name string, host string, hostPort int, password string) *Instance {
+	return &Instance{
+		Binding: Binding{
+			Name:        name,
+			Label:       serviceType.Label,
+			Tags:        serviceType.Tags,
+			Plan:        LocalPlan,
+			Credentials: serviceType.credentials(host, serviceType.Port, password),
+		},
+		ContainerName: ContainerName(name),
+		HostPort:      hostPort,
+	}
+}
+
+// NewUserProvidedInstance describes credentials for a service Cloud Rocker doesn't run itself, like 'cf cups'
+func NewUserProvidedInstance(name string, credentials map[string]interface{}, syslogDrainURL string) *Instance {
+	if credentials == nil {
+		credentials = map[string]interface{}{}
+	}
+	return &Instance{
+		Binding: Binding{
+			Name:           name,
+			Label:          UserProvidedLabel,
+			Tags:           []string{},
+			Credentials:    credentials,
+			SyslogDrainURL: syslogDrainURL,
+		},
+	}
+}
+
+// User-provided instances have no container
+func (instance *Instance) UserProvided() bool {
+	return instance.Label == UserProvidedLabel
+}
+
+func (instance *Instance) Brokered() bool {
+	return instance.Broker != nil
+}
+
+// BindingFor is the instance as the application sees it in VCAP_SERVICES - a brokered instance has the
+// credentials of the application's own binding
+func (instance *Instance) BindingFor(app string) Binding {
+	binding := instance.Binding
+	if instance.Brokered() {
+		if brokered, present := instance.Broker.Bindings[app]; present {
+			binding.Credentials = brokered.Credentials
+			binding.SyslogDrainURL = brokered.SyslogDrainURL
+		}
+	}
+	return binding
+}
+
+// ParseCredentials reads a JSON object of credentials, given inline or as the path to a file holding one
+func ParseCredentials(credentials string) (map[string]interface{}, error) {
+	parsed := map[string]interface{}{}
+	if credentials == "" {
+		return parsed, nil
+	}
+	credentialsBytes := []byte(credentials)
+	if !strings.HasPrefix(strings.TrimSpace(credentials), "{") {
+		var err error
+		if credentialsBytes, err = ioutil.ReadFile(credentials); err != nil {
+			return nil, fmt.Errorf("Credentials must be a JSON object or a file holding one: %s", err)
+		}
+	}
+	if err := json.Unmarshal(credentialsBytes, &parsed); err != nil {
+		return nil, fmt.Errorf("Credentials must be a JSON object: %s", err)
+	}
+	return parsed, nil
+}
+
+func ContainerName(name string) string {
+	return "cloudrocker-service-" + name
+}
+
+func NewPassword() (string, error) {
+	passwordBytes := make([]byte, 12)
+	if _, err := rand.Read(passwordBytes); err != nil {
+		return "", fmt.Errorf("Failed to generate a password: %s", err)
+	}
+	return fmt.Sprintf("%x", passwordBytes), nil
+}
+
+var serviceName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func ValidateName(name string) error {
+	if !serviceName.MatchString(name) {
+		return fmt.Errorf("Invalid service name %s - use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// Each instance is kept in its own JSON file in the services directory
+func Save(servicesDir string, instance *Instance) error {
+	if err := os.MkdirAll(servicesDir, 0755); err != nil {
+		return err
+	}
+	instanceBytes, err := json.MarshalIndent(instance, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(instancePath(servicesDir, instance.Name), instanceBytes, 0600)
+}
+
+func Load(servicesDir string, name string) (*Instance, error) {
+	instanceBytes, err := ioutil.ReadFile(instancePath(servicesDir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Service %s does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	instance := new(Instance)
+	if err := json.Unmarshal(instanceBytes, instance); err != nil {
+		return nil, fmt.Errorf("Failed to parse service %s: %s", name, err)
+	}
+	return instance, nil
+}
+
+func Exists(servicesDir string, name string) bool {
+	_, err := os.Stat(instancePath(servicesDir, name))
+	return err == nil
+}
+
+// List returns the instances sorted by name
+func List(servicesDir string) ([]*Instance, error) {
+	instances := []*Instance{}
+	paths, err := filepath.Glob(servicesDir + "/*.json")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		instance, err := Load(servicesDir, strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func Delete(servicesDir string, name string) error {
+	return os.Remove(instancePath(servicesDir, name))
+}
+
+func instancePath(servicesDir string, name string) string {
+	return servicesDir + "/" + name + ".json"
+}
@@ -0,0 +1,145 @@
+package stager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// The staging container exits with a code for the phase that failed, as Cloud Foundry's builder does, so the
+// host can say which phase it was
+const (
+	DetectFailCode   = 222
+	CompileFailCode  = 223
+	ReleaseFailCode  = 224
+	SupplyFailCode   = 225
+	FinalizeFailCode = 226
+)
+
+var phaseExitCodes = map[string]int{
+	"detect":   DetectFailCode,
+	"compile":  CompileFailCode,
+	"release":  ReleaseFailCode,
+	"supply":   SupplyFailCode,
+	"finalize": FinalizeFailCode,
+}
+
+// The single buildpack runner's errors only say which phase failed in their messages
+var phaseMessages = map[string]string{
+	"None of the buildpacks detected": "detect",
+	"Failed to compile droplet":       "compile",
+	"Failed to build droplet release": "release",
+	"Failed to read command from":     "release",
+}
+
+// StagingError is a buildpack phase that failed while staging
+type StagingError struct {
+	Phase string
+	Err   error
+}
+
+func (stagingError *StagingError) Error() string {
+	return stagingError.Err.Error()
+}
+
+func phaseError(phase string, format string, args ...interface{}) error {
+	return &StagingError{Phase: phase, Err: fmt.Errorf(format, args...)}
+}
+
+// ExitCode is what rock stage internal exits with for a staging error
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if stagingError, ok := err.(*StagingError); ok {
+		if code, ok := phaseExitCodes[stagingError.Phase]; ok {
+			return code
+		}
+	}
+	return 1
+}
+
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*StagingError); ok {
+		return err
+	}
+	for message, phase := range phaseMessages {
+		if strings.HasPrefix(err.Error(), message) {
+			return &StagingError{Phase: phase, Err: err}
+		}
+	}
+	return err
+}
+
+// StagingFailure describes a staging container that exited with exitCode, with the last of its output
+func StagingFailure(exitCode int, outputTail []string) error {
+	message := fmt.Sprintf("Staging failed with exit status %d", exitCode)
+	for phase, code := range phaseExitCodes {
+		if code == exitCode {
+			message = fmt.Sprintf("Staging failed in the %s phase (exit status %d)", phase, exitCode)
+		}
+	}
+	if exitCode == DetectFailCode {
+		message += " - have you added a buildpack for this type of application?"
+	}
+	if len(outputTail) > 0 {
+		message += " - the last of the staging output was:\n" + strings.Join(outputTail, "\n")
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// OutputTail passes output through to a writer, keeping its last lines to report if staging fails
+type OutputTail struct {
+	writer  io.Writer
+	size    int
+	lines   []string
+	partial bytes.Buffer
+	mutex   sync.Mutex
+}
+
+func NewOutputTail(writer io.Writer, size int) *OutputTail {
+	return &OutputTail{writer: writer, size: size}
+}
+
+func (tail *OutputTail) Write(p []byte) (int, error) {
+	tail.mutex.Lock()
+	defer tail.mutex.Unlock()
+	tail.partial.Write(p)
+	for {
+		line, err := tail.partial.ReadString('\n')
+		if err != nil {
+			// an unfinished line waits for the rest of it
+			tail.partial.Reset()
+			tail.partial.WriteString(line)
+			break
+		}
+		tail.keep(strings.TrimRight(line, "\r\n"))
+	}
+	return tail.writer.Write(p)
+}
+
+func (tail *OutputTail) keep(line string) {
+	tail.lines = append(tail.lines, line)
+	if len(tail.lines) > tail.size {
+		tail.lines = tail.lines[len(tail.lines)-tail.size:]
+	}
+}
+
+// Lines are the last lines written, including any unfinished last line
+func (tail *OutputTail) Lines() []string {
+	tail.mutex.Lock()
+	defer tail.mutex.Unlock()
+	lines := append([]string{}, tail.lines...)
+	if tail.partial.Len() > 0 {
+		lines = append(lines, tail.partial.String())
+	}
+	if len(lines) > tail.size {
+		lines = lines[len(lines)-tail.size:]
+	}
+	return lines
+}
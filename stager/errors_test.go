@@ -0,0 +1,69 @@
+package stager_test
+
+import (
+	"errors"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega/gbytes"
+	"github.com/cloudcredo/cloudrocker/stager"
+)
+
+type FailingRunner struct {
+	err error
+}
+
+func (f *FailingRunner) Run() error {
+	return f.err
+}
+
+var _ = Describe("Staging errors", func() {
+	Describe("Exit codes for a failed buildpack run", func() {
+		It("should exit with the code for the phase that failed", func() {
+			buffer := gbytes.NewBuffer()
+			err := stager.RunBuildpack(buffer, &FailingRunner{errors.New("None of the buildpacks detected a compatible application")})
+			Expect(stager.ExitCode(err)).To(Equal(stager.DetectFailCode))
+			err = stager.RunBuildpack(buffer, &FailingRunner{errors.New("Failed to compile droplet: exit status 1")})
+			Expect(stager.ExitCode(err)).To(Equal(stager.CompileFailCode))
+			Expect(err).Should(MatchError("Failed to compile droplet: exit status 1"))
+			err = stager.RunBuildpack(buffer, &FailingRunner{errors.New("Failed to build droplet release: exit status 1")})
+			Expect(stager.ExitCode(err)).To(Equal(stager.ReleaseFailCode))
+			err = stager.RunBuildpack(buffer, &FailingRunner{&stager.StagingError{Phase: "supply", Err: errors.New("no supply")}})
+			Expect(stager.ExitCode(err)).To(Equal(stager.SupplyFailCode))
+		})
+
+		It("should exit with 1 for other failures, and 0 without one", func() {
+			err := stager.RunBuildpack(gbytes.NewBuffer(), &FailingRunner{errors.New("Failed to set up filesystem when generating droplet")})
+			Expect(stager.ExitCode(err)).To(Equal(1))
+			Expect(stager.ExitCode(nil)).To(Equal(0))
+		})
+	})
+
+	Describe("Describing a failed staging container", func() {
+		It("should name the failing phase and include the output's tail", func() {
+			err := stager.StagingFailure(stager.CompileFailCode, []string{"-----> Installing ruby", "bundler failed"})
+			Expect(err).Should(MatchError("Staging failed in the compile phase (exit status 223) - the last of the staging output was:\n-----> Installing ruby\nbundler failed"))
+		})
+
+		It("should suggest adding a buildpack when detection failed", func() {
+			err := stager.StagingFailure(stager.DetectFailCode, nil)
+			Expect(err).Should(MatchError("Staging failed in the detect phase (exit status 222) - have you added a buildpack for this type of application?"))
+		})
+
+		It("should give the exit status of an unknown failure", func() {
+			err := stager.StagingFailure(1, nil)
+			Expect(err).Should(MatchError("Staging failed with exit status 1"))
+		})
+	})
+
+	Describe("Keeping the tail of the staging output", func() {
+		It("should pass output through and keep its last lines", func() {
+			buffer := gbytes.NewBuffer()
+			tail := stager.NewOutputTail(buffer, 2)
+			tail.Write([]byte("one\ntwo\nth"))
+			tail.Write([]byte("ree\nfour"))
+			Expect(tail.Lines()).To(Equal([]string{"three", "four"}))
+			Expect(string(buffer.Contents())).To(Equal("one\ntwo\nthree\nfour"))
+		})
+	})
+})
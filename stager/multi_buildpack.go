@@ -0,0 +1,284 @@
+package stager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cloudcredo/cloudrocker/config"
+
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle/buildpackrunner"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle/protocol"
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+// MultiBuildpackRunner stages with several buildpacks the way Cloud Foundry does: every buildpack supplies its
+// dependencies into its own numbered deps directory, then the last one finalizes the application and provides
+// the start command. The droplet it writes has the same layout as a single buildpack's, with the deps directory
+// inside the app directory
+type MultiBuildpackRunner struct {
+	config  *buildpack_app_lifecycle.LifecycleBuilderConfig
+	depsDir string
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func NewMultiBuildpackRunner(config *buildpack_app_lifecycle.LifecycleBuilderConfig, depsDir string) *MultiBuildpackRunner {
+	return &MultiBuildpackRunner{
+		config:  config,
+		depsDir: depsDir,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+	}
+}
+
+func (runner *MultiBuildpackRunner) Run() error {
+	if err := runner.makeDirectories(); err != nil {
+		return fmt.Errorf("Failed to set up filesystem when generating droplet: %s", err)
+	}
+	if err := runner.downloadBuildpacks(); err != nil {
+		return err
+	}
+
+	buildpacks := runner.config.BuildpackOrder()
+	last := len(buildpacks) - 1
+	for index, buildpack := range buildpacks {
+		buildpackDir, err := runner.buildpackPath(buildpack)
+		if err != nil {
+			return err
+		}
+		if err := runner.supply(buildpack, buildpackDir, index, index == last); err != nil {
+			return err
+		}
+		if index == last {
+			if err := runner.finalize(buildpack, buildpackDir, index); err != nil {
+				return err
+			}
+		}
+	}
+
+	lastBuildpackDir, _ := runner.buildpackPath(buildpacks[last])
+	startCommand, err := runner.startCommand(lastBuildpackDir)
+	if err != nil {
+		return err
+	}
+	if startCommand == "" {
+		fmt.Fprintln(runner.stderr, "No start command detected; command must be provided at runtime")
+	}
+
+	return runner.writeDroplet(buildpacks[last], startCommand)
+}
+
+func (runner *MultiBuildpackRunner) makeDirectories() error {
+	for _, dir := range []string{
+		filepath.Dir(runner.config.OutputDroplet()),
+		filepath.Dir(runner.config.OutputMetadata()),
+		runner.config.BuildArtifactsCacheDir(),
+		runner.depsDir,
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (runner *MultiBuildpackRunner) downloadBuildpacks() error {
+	for _, buildpack := range runner.config.BuildpackOrder() {
+		buildpackURL, err := url.Parse(buildpack)
+		if err != nil {
+			return fmt.Errorf("Invalid buildpack url (%s): %s", buildpack, err)
+		}
+		if !buildpackURL.IsAbs() {
+			continue
+		}
+		destination := runner.config.BuildpackPath(buildpack)
+		if buildpackrunner.IsZipFile(buildpackURL.Path) {
+			_, err = buildpackrunner.NewZipDownloader(runner.config.SkipCertVerify()).DownloadAndExtract(buildpackURL, destination)
+		} else {
+			err = buildpackrunner.GitClone(*buildpackURL, destination)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A buildpack's bin directory may be at its top level or inside the single directory a zip file unpacked to
+func (runner *MultiBuildpackRunner) buildpackPath(buildpack string) (string, error) {
+	buildpackPath := runner.config.BuildpackPath(buildpack)
+	if hasBinDirectory(buildpackPath) {
+		return buildpackPath, nil
+	}
+	files, err := ioutil.ReadDir(buildpackPath)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read buildpack directory '%s' for buildpack '%s'", buildpackPath, buildpack)
+	}
+	if len(files) == 1 && hasBinDirectory(path.Join(buildpackPath, files[0].Name())) {
+		return path.Join(buildpackPath, files[0].Name()), nil
+	}
+	return "", fmt.Errorf("malformed buildpack does not contain a /bin dir: %s", buildpack)
+}
+
+// Every buildpack but the last must supply; the last one supplies too when it can, as finalize expects its own
+// supply to have run
+func (runner *MultiBuildpackRunner) supply(buildpack string, buildpackDir string, index int, last bool) error {
+	supply := path.Join(buildpackDir, "bin", "supply")
+	if _, err := os.Stat(supply); err != nil {
+		if last {
+			return nil
+		}
+		return phaseError("supply", "Buildpack %s does not support multi-buildpack staging - it has no bin/supply", buildpack)
+	}
+	if err := os.MkdirAll(path.Join(runner.depsDir, strconv.Itoa(index)), 0755); err != nil {
+		return err
+	}
+	cacheDir, err := runner.cacheDir(buildpack)
+	if err != nil {
+		return err
+	}
+	if err := runner.run(exec.Command(supply, runner.config.BuildDir(), cacheDir, runner.depsDir, strconv.Itoa(index)), runner.stdout); err != nil {
+		return phaseError("supply", "Failed to run supply for buildpack %s: %s", buildpack, err)
+	}
+	return nil
+}
+
+func (runner *MultiBuildpackRunner) finalize(buildpack string, buildpackDir string, index int) error {
+	finalize := path.Join(buildpackDir, "bin", "finalize")
+	if _, err := os.Stat(finalize); err != nil {
+		return phaseError("finalize", "Buildpack %s cannot be the last buildpack - it has no bin/finalize", buildpack)
+	}
+	if err := os.MkdirAll(path.Join(runner.depsDir, strconv.Itoa(index)), 0755); err != nil {
+		return err
+	}
+	cacheDir, err := runner.cacheDir(buildpack)
+	if err != nil {
+		return err
+	}
+	profileDir := path.Join(runner.config.BuildDir(), ".profile.d")
+	if err := os.MkdirAll(profileDir, 0755); err != nil {
+		return err
+	}
+	if err := runner.run(exec.Command(finalize, runner.config.BuildDir(), cacheDir, runner.depsDir, strconv.Itoa(index), profileDir), runner.stdout); err != nil {
+		return phaseError("finalize", "Failed to run finalize for buildpack %s: %s", buildpack, err)
+	}
+	return nil
+}
+
+// Each buildpack gets a cache directory of its own, so they don't trip over each other's cached files
+func (runner *MultiBuildpackRunner) cacheDir(buildpack string) (string, error) {
+	cacheDir := path.Join(runner.config.BuildArtifactsCacheDir(), md5sum(buildpack))
+	return cacheDir, os.MkdirAll(cacheDir, 0755)
+}
+
+// The Procfile's web command wins over the last buildpack's release, which is optional for finalize buildpacks
+func (runner *MultiBuildpackRunner) startCommand(buildpackDir string) (string, error) {
+	procfile, err := config.ParseProcfile(path.Join(runner.config.BuildDir(), "Procfile"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", phaseError("release", "Failed to read command from Procfile: %s", err)
+	}
+	if procfile[config.WebProcess] != "" {
+		return procfile[config.WebProcess], nil
+	}
+	releaseBin := path.Join(buildpackDir, "bin", "release")
+	if _, err := os.Stat(releaseBin); err != nil {
+		return "", nil
+	}
+	output := new(bytes.Buffer)
+	if err := runner.run(exec.Command(releaseBin, runner.config.BuildDir()), output); err != nil {
+		return "", phaseError("release", "Failed to build droplet release: %s", err)
+	}
+	parsedRelease := buildpackrunner.Release{}
+	if err := candiedyaml.NewDecoder(output).Decode(&parsedRelease); err != nil {
+		return "", phaseError("release", "Failed to build droplet release: buildpack's release output invalid: %s", err)
+	}
+	return parsedRelease.DefaultProcessTypes.Web, nil
+}
+
+func (runner *MultiBuildpackRunner) writeDroplet(buildpack string, startCommand string) error {
+	tarPath, err := exec.LookPath("tar")
+	if err != nil {
+		return err
+	}
+	contentsDir, err := ioutil.TempDir("", "contents")
+	if err != nil {
+		return fmt.Errorf("Failed to create droplet contents dir: %s", err)
+	}
+	defer os.RemoveAll(contentsDir)
+
+	if err := runner.saveInfo(path.Join(contentsDir, "staging_info.yml"), buildpack, startCommand); err != nil {
+		return fmt.Errorf("Failed to encode generated metadata: %s", err)
+	}
+	appDir := path.Join(contentsDir, "app")
+	if err := runner.run(exec.Command("cp", "-a", runner.config.BuildDir(), appDir), runner.stdout); err != nil {
+		return fmt.Errorf("Failed to copy compiled droplet: %s", err)
+	}
+	if err := runner.run(exec.Command("cp", "-a", runner.depsDir, path.Join(appDir, config.DepsDir)), runner.stdout); err != nil {
+		return fmt.Errorf("Failed to copy supplied dependencies: %s", err)
+	}
+	for _, dir := range []string{"tmp", "logs"} {
+		if err := os.MkdirAll(path.Join(contentsDir, dir), 0755); err != nil {
+			return fmt.Errorf("Failed to set up droplet filesystem: %s", err)
+		}
+	}
+	if err := exec.Command(tarPath, "-czf", runner.config.OutputDroplet(), "-C", contentsDir, ".").Run(); err != nil {
+		return fmt.Errorf("Failed to compress droplet: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(runner.config.OutputBuildArtifactsCache()), 0755); err != nil {
+		return fmt.Errorf("Failed to create output build artifacts cache dir: %s", err)
+	}
+	if err := exec.Command(tarPath, "-czf", runner.config.OutputBuildArtifactsCache(), "-C", runner.config.BuildArtifactsCacheDir(), ".").Run(); err != nil {
+		return fmt.Errorf("Failed to compress build artifacts: %s", err)
+	}
+	return nil
+}
+
+func (runner *MultiBuildpackRunner) saveInfo(infoFilePath string, buildpack string, startCommand string) error {
+	infoFile, err := os.Create(infoFilePath)
+	if err != nil {
+		return err
+	}
+	defer infoFile.Close()
+	if err := candiedyaml.NewEncoder(infoFile).Encode(buildpackrunner.DeaStagingInfo{
+		StartCommand: startCommand,
+	}); err != nil {
+		return err
+	}
+
+	executionMetadata, err := json.Marshal(protocol.ExecutionMetadata{StartCommand: startCommand})
+	if err != nil {
+		return err
+	}
+	resultFile, err := os.Create(runner.config.OutputMetadata())
+	if err != nil {
+		return err
+	}
+	defer resultFile.Close()
+	return json.NewEncoder(resultFile).Encode(buildpack_app_lifecycle.StagingResult{
+		BuildpackKey:         buildpack,
+		ExecutionMetadata:    string(executionMetadata),
+		DetectedStartCommand: map[string]string{"web": startCommand},
+	})
+}
+
+func (runner *MultiBuildpackRunner) run(cmd *exec.Cmd, output io.Writer) error {
+	cmd.Stdout = output
+	cmd.Stderr = runner.stderr
+	return cmd.Run()
+}
+
+func hasBinDirectory(dir string) bool {
+	_, err := os.Stat(path.Join(dir, "bin"))
+	return err == nil
+}
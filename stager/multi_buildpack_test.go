@@ -0,0 +1,113 @@
+package stager_test
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/cloudfoundry-incubator/buildpack_app_lifecycle"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+	"github.com/cloudcredo/cloudrocker/stager"
+)
+
+var _ = Describe("MultiBuildpackRunner", func() {
+	var (
+		tmpDir        string
+		buildpacksDir string
+	)
+
+	writeBuildpack := func(name string, scripts map[string]string) {
+		binDir := buildpacksDir + "/" + fmt.Sprintf("%x", md5.Sum([]byte(name))) + "/bin"
+		os.MkdirAll(binDir, 0755)
+		for script, contents := range scripts {
+			ioutil.WriteFile(binDir+"/"+script, []byte("#!/bin/bash\n"+contents+"\n"), 0755)
+		}
+	}
+
+	newRunner := func(buildpacks ...string) *stager.MultiBuildpackRunner {
+		config := buildpack_app_lifecycle.NewLifecycleBuilderConfig(buildpacks, true, false)
+		config.Set("buildDir", tmpDir+"/app")
+		config.Set("outputDroplet", tmpDir+"/droplet")
+		config.Set("outputMetadata", tmpDir+"/result.json")
+		config.Set("outputBuildArtifactsCache", tmpDir+"/output-cache")
+		config.Set("buildpacksDir", buildpacksDir)
+		config.Set("buildArtifactsCacheDir", tmpDir+"/cache")
+		return stager.NewMultiBuildpackRunner(&config, tmpDir+"/deps")
+	}
+
+	BeforeEach(func() {
+		tmpDir, _ = ioutil.TempDir(os.TempDir(), "stager-test-multi-buildpack")
+		buildpacksDir = tmpDir + "/buildpacks"
+		os.MkdirAll(tmpDir+"/app", 0755)
+		ioutil.WriteFile(tmpDir+"/app/app.py", []byte("print('hello')"), 0644)
+		writeBuildpack("binary_buildpack", map[string]string{
+			"supply": `echo "supplied by $4" > "$3/$4/binary"`,
+		})
+		writeBuildpack("python_buildpack", map[string]string{
+			"supply":   `echo "supplied by $4" > "$3/$4/python"`,
+			"finalize": `cat "$3/0/binary" "$3/$4/python" > "$1/finalized" && echo 'export PATH=$DEPS_DIR/1/bin:$PATH' > "$5/python.sh"`,
+			"release":  `echo "default_process_types:"; echo "  web: python app.py"`,
+		})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should supply with every buildpack, finalize with the last and write a droplet", func() {
+		err := newRunner("binary_buildpack", "python_buildpack").Run()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		finalized, err := ioutil.ReadFile(tmpDir + "/app/finalized")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(finalized)).To(Equal("supplied by 0\nsupplied by 1\n"))
+
+		dropletDir := tmpDir + "/unpacked"
+		os.MkdirAll(dropletDir, 0755)
+		err = exec.Command("tar", "-xzf", tmpDir+"/droplet", "-C", dropletDir).Run()
+		Expect(err).ShouldNot(HaveOccurred())
+		for _, file := range []string{"staging_info.yml", "app/app.py", "app/finalized", "app/.profile.d/python.sh", "app/.deps/0/binary", "app/.deps/1/python"} {
+			_, err := os.Stat(dropletDir + "/" + file)
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		_, err = os.Stat(tmpDir + "/output-cache")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		resultBytes, err := ioutil.ReadFile(tmpDir + "/result.json")
+		Expect(err).ShouldNot(HaveOccurred())
+		var result buildpack_app_lifecycle.StagingResult
+		Expect(json.Unmarshal(resultBytes, &result)).ShouldNot(HaveOccurred())
+		Expect(result.BuildpackKey).To(Equal("python_buildpack"))
+		Expect(result.DetectedStartCommand).To(Equal(map[string]string{"web": "python app.py"}))
+	})
+
+	It("should prefer the Procfile's web command over the last buildpack's release", func() {
+		ioutil.WriteFile(tmpDir+"/app/Procfile", []byte("web: gunicorn app\n"), 0644)
+		err := newRunner("binary_buildpack", "python_buildpack").Run()
+		Expect(err).ShouldNot(HaveOccurred())
+		resultBytes, _ := ioutil.ReadFile(tmpDir + "/result.json")
+		Expect(string(resultBytes)).To(ContainSubstring(`"detected_start_command":{"web":"gunicorn app"}`))
+	})
+
+	It("should fail when a buildpack before the last cannot supply", func() {
+		writeBuildpack("ruby_buildpack", map[string]string{"compile": "true"})
+		err := newRunner("ruby_buildpack", "python_buildpack").Run()
+		Expect(err).Should(MatchError("Buildpack ruby_buildpack does not support multi-buildpack staging - it has no bin/supply"))
+	})
+
+	It("should fail when the last buildpack cannot finalize", func() {
+		err := newRunner("python_buildpack", "binary_buildpack").Run()
+		Expect(err).Should(MatchError("Buildpack binary_buildpack cannot be the last buildpack - it has no bin/finalize"))
+	})
+
+	It("should fail when a buildpack's supply fails", func() {
+		writeBuildpack("binary_buildpack", map[string]string{"supply": "exit 1"})
+		err := newRunner("binary_buildpack", "python_buildpack").Run()
+		Expect(err).Should(MatchError("Failed to run supply for buildpack binary_buildpack: exit status 1"))
+		Expect(stager.ExitCode(err)).To(Equal(stager.SupplyFailCode))
+	})
+})
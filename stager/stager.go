@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/cloudcredo/cloudrocker/buildpack"
 	"github.com/cloudcredo/cloudrocker/config"
 	"github.com/cloudcredo/cloudrocker/utils"
 
@@ -20,17 +21,34 @@ type BuildpackRunner interface {
 
 func RunBuildpack(writer io.Writer, runner BuildpackRunner) error {
 	fmt.Fprintln(writer, "Running Buildpacks...")
-	return runner.Run()
+	return classifyError(runner.Run())
 }
 
-func NewBuildpackRunner(buildpackDir string) *buildpackrunner.Runner {
+// Chosen buildpacks are used without running detect, as with cf push -b; otherwise every installed buildpack
+// is detected, in the user's detect order. More than one chosen buildpack stages with supply and finalize,
+// supplying into depsDir
+func NewBuildpackRunner(buildpackDir string, depsDir string, chosenBuildpacks []string) BuildpackRunner {
 	prepareMd5BuildpacksDir(buildpackDir, "/tmp/buildpacks")
 	var err error
 	dirs := []string{}
-	if dirs, err = utils.SubDirs(buildpackDir); err != nil {
+	if dirs, err = buildpack.DetectOrder(buildpackDir); err != nil {
 		log.Fatalf(" %s", err)
 	}
-	config := buildpack_app_lifecycle.NewLifecycleBuilderConfig(dirs, false, false)
+	for _, chosenBuildpack := range chosenBuildpacks {
+		if !buildpack.IsURL(chosenBuildpack) && !contains(dirs, chosenBuildpack) {
+			log.Fatalf(" Buildpack %s is not installed", chosenBuildpack)
+		}
+	}
+	if len(chosenBuildpacks) > 1 {
+		config := buildpack_app_lifecycle.NewLifecycleBuilderConfig(chosenBuildpacks, true, false)
+		return NewMultiBuildpackRunner(&config, depsDir)
+	}
+	skipDetect := false
+	if len(chosenBuildpacks) == 1 {
+		dirs = chosenBuildpacks
+		skipDetect = true
+	}
+	config := buildpack_app_lifecycle.NewLifecycleBuilderConfig(dirs, skipDetect, false)
 	return buildpackrunner.New(&config)
 }
 
@@ -44,6 +62,15 @@ func ValidateStagedApp(directories *config.Directories) error {
 	return nil
 }
 
+// RemoveOutputBuildArtifactsCache deletes the tarball of the cache that staging leaves behind. The cache directory
+// is mounted into the staging container, so buildpacks already left it up to date and the tarball is only a copy
+func RemoveOutputBuildArtifactsCache(directories *config.Directories) error {
+	if err := os.Remove(directories.OutputBuildArtifactsCache()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func prepareMd5BuildpacksDir(src string, dst string) {
 	os.MkdirAll(src, 0755)
 	os.MkdirAll(dst, 0755)
@@ -59,6 +86,15 @@ func prepareMd5BuildpacksDir(src string, dst string) {
 	}
 }
 
+func contains(list []string, item string) bool {
+	for _, listItem := range list {
+		if listItem == item {
+			return true
+		}
+	}
+	return false
+}
+
 func md5sum(src string) string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(src)))
 }
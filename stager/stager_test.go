@@ -40,7 +40,7 @@ var _ = Describe("Stager", func() {
 			buildpackDir, _ := ioutil.TempDir(os.TempDir(), "crocker-buildpackrunner-test")
 			os.Mkdir(buildpackDir+"/test-buildpack", 0755)
 			ioutil.WriteFile(buildpackDir+"/test-buildpack"+"/testfile", []byte("test"), 0644)
-			runner := stager.NewBuildpackRunner(buildpackDir)
+			runner := stager.NewBuildpackRunner(buildpackDir, buildpackDir+"/deps", []string{})
 			var runnerVar *buildpackrunner.Runner
 			Expect(runner).Should(BeAssignableToTypeOf(runnerVar))
 			md5BuildpackName := fmt.Sprintf("%x", md5.Sum([]byte("test-buildpack")))
@@ -66,28 +66,65 @@ var _ = Describe("Stager", func() {
 
 		Context("with something that looks like a staged application", func() {
 			It("should not return an error", func() {
-				dropletDir := config.NewDirectories(cfhome).Tmp()
+				dropletDir := config.NewDirectories(cfhome, "app").Tmp()
 				os.MkdirAll(dropletDir+"/tmp", 0755)
 				ioutil.WriteFile(dropletDir+"/result.json", []byte("test-staging-info"), 0644)
 				ioutil.WriteFile(dropletDir+"/droplet", []byte("test-droplet"), 0644)
-				err := stager.ValidateStagedApp(config.NewDirectories(cfhome))
+				err := stager.ValidateStagedApp(config.NewDirectories(cfhome, "app"))
 				Expect(err).ShouldNot(HaveOccurred())
 			})
 		})
 		Context("without something that looks like a staged application", func() {
 			Context("because we have no droplet", func() {
 				It("should return an error about a missing droplet", func() {
-					err := stager.ValidateStagedApp(config.NewDirectories(cfhome))
+					err := stager.ValidateStagedApp(config.NewDirectories(cfhome, "app"))
 					Expect(err).Should(MatchError("Staging failed - have you added a buildpack for this type of application?"))
 				})
 			})
 			Context("because we have no staging_info.yml", func() {
 				It("should return an error about missing staging info", func() {
-					os.MkdirAll(cfhome+"/tmp/droplet/app", 0755)
-					err := stager.ValidateStagedApp(config.NewDirectories(cfhome))
+					os.MkdirAll(cfhome+"/apps/app/tmp/droplet/app", 0755)
+					err := stager.ValidateStagedApp(config.NewDirectories(cfhome, "app"))
 					Expect(err).Should(MatchError("Staging failed - no result json was produced by the matching buildpack!"))
 				})
 			})
 		})
 	})
+
+	Describe("Removing the output build artifact cache", func() {
+		var (
+			cfhome      string
+			directories *config.Directories
+		)
+
+		BeforeEach(func() {
+			cfhome, _ = ioutil.TempDir(os.TempDir(), "stager-test-cache")
+			directories = config.NewDirectories(cfhome, "app")
+			os.MkdirAll(directories.Tmp(), 0755)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(cfhome)
+		})
+
+		It("should delete the tarball staging left behind, leaving the cache directory alone", func() {
+			os.MkdirAll(directories.BuildArtifactsCache()+"/maven", 0755)
+			ioutil.WriteFile(directories.BuildArtifactsCache()+"/maven/dependency.jar", []byte("jar"), 0644)
+			ioutil.WriteFile(directories.OutputBuildArtifactsCache(), []byte("not even a tarball"), 0644)
+
+			err := stager.RemoveOutputBuildArtifactsCache(directories)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = os.Stat(directories.OutputBuildArtifactsCache())
+			Expect(os.IsNotExist(err)).To(Equal(true))
+			contents, err := ioutil.ReadFile(directories.BuildArtifactsCache() + "/maven/dependency.jar")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("jar"))
+		})
+
+		It("should not mind when there is no output cache", func() {
+			err := stager.RemoveOutputBuildArtifactsCache(directories)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+	})
 })
@@ -0,0 +1,99 @@
+package stager
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/cloudcredo/cloudrocker/appfiles"
+	"github.com/cloudcredo/cloudrocker/buildpack"
+	"github.com/cloudcredo/cloudrocker/config"
+)
+
+// StagingHash identifies everything a staging depends on: the application's files, the versions of the buildpacks
+// it could be staged with, the stack and the staging environment. Buildpack URLs are downloaded again by every
+// staging, so only the URL, including any #ref, is part of the hash
+func StagingHash(application *config.Application, buildpackDir string) (string, error) {
+	hash := sha1.New()
+	appDigest, err := appfiles.Digest(application.Path)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(hash, "app\x00%s\x00", appDigest)
+
+	buildpacks := application.ChosenBuildpacks()
+	if len(buildpacks) == 0 {
+		//detection tries every installed buildpack, in order
+		if buildpacks, err = buildpack.DetectOrder(buildpackDir); err != nil {
+			return "", err
+		}
+	}
+	for _, chosen := range buildpacks {
+		version := chosen
+		if !buildpack.IsURL(chosen) {
+			if version, err = buildpack.Version(chosen, buildpackDir); err != nil {
+				return "", err
+			}
+		}
+		fmt.Fprintf(hash, "buildpack\x00%s\x00%s\x00", chosen, version)
+	}
+
+	fmt.Fprintf(hash, "stack\x00%s\x00", config.Stack)
+
+	envVars := config.StagingEnvironment(application).EnvVars()
+	names := []string{}
+	for name := range envVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(hash, "env\x00%s\x00%s\x00", name, envVars[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// WriteStagingHash keeps the hash of a successful staging next to the droplet it produced
+func WriteStagingHash(directories *config.Directories, hash string) error {
+	return ioutil.WriteFile(stagingHashFile(directories), []byte(hash+"\n"), 0644)
+}
+
+// ForgetStagingHash is for when the droplet is about to be replaced, so a staging that fails part way doesn't leave
+// the old hash or imported marker describing whatever droplet it left behind
+func ForgetStagingHash(directories *config.Directories) error {
+	if err := os.Remove(stagingHashFile(directories)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// StagedWith tells whether the application's droplet was staged from exactly what the hash describes, so staging
+// it again would produce the same droplet
+func StagedWith(directories *config.Directories, hash string) bool {
+	if _, err := os.Stat(directories.Tmp() + "/droplet"); err != nil {
+		return false
+	}
+	stagedHash, err := ioutil.ReadFile(stagingHashFile(directories))
+	return err == nil && string(stagedHash) == hash+"\n"
+}
+
+// An imported droplet wasn't staged here, so in place of a hash it is marked as imported
+const importedMarker = "imported"
+
+// MarkImported records that the droplet was imported, so it is used as it is until the application is staged again
+func MarkImported(directories *config.Directories) error {
+	return ioutil.WriteFile(stagingHashFile(directories), []byte(importedMarker+"\n"), 0644)
+}
+
+// Imported tells whether the application's droplet is one that was imported rather than staged here
+func Imported(directories *config.Directories) bool {
+	return StagedWith(directories, importedMarker)
+}
+
+// The hash is kept in the application's home rather than its tmp dir, which staging empties and mounts into the
+// staging container
+func stagingHashFile(directories *config.Directories) string {
+	return directories.AppHome() + "/staging-hash"
+}
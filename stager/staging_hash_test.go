@@ -0,0 +1,106 @@
+package stager_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/ginkgo"
+	. "github.com/cloudcredo/cloudrocker/Godeps/_workspace/src/github.com/onsi/gomega"
+	"github.com/cloudcredo/cloudrocker/config"
+	"github.com/cloudcredo/cloudrocker/stager"
+)
+
+var _ = Describe("Staging hash", func() {
+	var (
+		tmpDir       string
+		buildpackDir string
+		application  *config.Application
+		hash         string
+	)
+
+	BeforeEach(func() {
+		tmpDir, _ = ioutil.TempDir(os.TempDir(), "stager-test-staging-hash")
+		buildpackDir = tmpDir + "/buildpacks"
+		os.MkdirAll(buildpackDir+"/test-buildpack/bin", 0755)
+		ioutil.WriteFile(buildpackDir+"/test-buildpack/bin/detect", []byte("#!/bin/bash"), 0755)
+		os.MkdirAll(tmpDir+"/app", 0755)
+		ioutil.WriteFile(tmpDir+"/app/server.js", []byte("require('express')"), 0644)
+		application = config.NewApplication(tmpDir + "/app")
+		var err error
+		hash, err = stager.StagingHash(application, buildpackDir)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("should not change when nothing has changed", func() {
+		Expect(stager.StagingHash(application, buildpackDir)).To(Equal(hash))
+	})
+
+	It("should change when the application changes", func() {
+		ioutil.WriteFile(tmpDir+"/app/server.js", []byte("require('koa')"), 0644)
+		Expect(stager.StagingHash(application, buildpackDir)).ToNot(Equal(hash))
+	})
+
+	It("should change when a buildpack changes", func() {
+		ioutil.WriteFile(buildpackDir+"/test-buildpack/bin/detect", []byte("#!/bin/bash\nexit 1"), 0755)
+		Expect(stager.StagingHash(application, buildpackDir)).ToNot(Equal(hash))
+	})
+
+	It("should change when a different buildpack is chosen", func() {
+		application.Buildpack = "https://github.com/cloudfoundry/ruby-buildpack#v1.6.0"
+		Expect(stager.StagingHash(application, buildpackDir)).ToNot(Equal(hash))
+	})
+
+	It("should change when the staging environment changes", func() {
+		application.UserEnv["BP_DEBUG"] = "true"
+		Expect(stager.StagingHash(application, buildpackDir)).ToNot(Equal(hash))
+	})
+
+	It("should tell whether the droplet was staged with the hash", func() {
+		directories := config.NewDirectories(tmpDir+"/home", "app")
+		os.MkdirAll(directories.Tmp(), 0755)
+		Expect(stager.WriteStagingHash(directories, hash)).ShouldNot(HaveOccurred())
+		Expect(stager.StagedWith(directories, hash)).To(Equal(false))
+
+		ioutil.WriteFile(directories.Tmp()+"/droplet", []byte("droplet"), 0644)
+		Expect(stager.StagedWith(directories, hash)).To(Equal(true))
+		Expect(stager.StagedWith(directories, "another")).To(Equal(false))
+
+		Expect(stager.Imported(directories)).To(Equal(false))
+	})
+
+	It("should tell whether the droplet was imported", func() {
+		directories := config.NewDirectories(tmpDir+"/home", "app")
+		os.MkdirAll(directories.Tmp(), 0755)
+		ioutil.WriteFile(directories.Tmp()+"/droplet", []byte("droplet"), 0644)
+		Expect(stager.WriteStagingHash(directories, hash)).ShouldNot(HaveOccurred())
+		Expect(stager.Imported(directories)).To(Equal(false))
+
+		Expect(stager.MarkImported(directories)).ShouldNot(HaveOccurred())
+		Expect(stager.Imported(directories)).To(Equal(true))
+		Expect(stager.StagedWith(directories, hash)).To(Equal(false))
+	})
+
+	It("should keep the hash out of the tmp dir that staging empties", func() {
+		directories := config.NewDirectories(tmpDir+"/home", "app")
+		os.MkdirAll(directories.Tmp(), 0755)
+		Expect(stager.MarkImported(directories)).ShouldNot(HaveOccurred())
+		_, err := os.Stat(directories.AppHome() + "/staging-hash")
+		Expect(err).ShouldNot(HaveOccurred())
+		files, _ := ioutil.ReadDir(directories.Tmp())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("should forget the hash when the droplet is about to be replaced", func() {
+		directories := config.NewDirectories(tmpDir+"/home", "app")
+		os.MkdirAll(directories.Tmp(), 0755)
+		ioutil.WriteFile(directories.Tmp()+"/droplet", []byte("droplet"), 0644)
+		Expect(stager.MarkImported(directories)).ShouldNot(HaveOccurred())
+		Expect(stager.ForgetStagingHash(directories)).ShouldNot(HaveOccurred())
+		Expect(stager.Imported(directories)).To(Equal(false))
+		Expect(stager.ForgetStagingHash(directories)).ShouldNot(HaveOccurred())
+	})
+})
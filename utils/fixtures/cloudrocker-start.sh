@@ -1,6 +1,12 @@
 
 cd "$1"
 
+if [ -n "$DEPS_DIR" ] && [ -d "$DEPS_DIR" ]; then
+  for env_file in "$DEPS_DIR"/*/profile.d/*; do
+    [ -f "$env_file" ] && source "$env_file"
+  done
+fi
+
 if [ -d .profile.d ]; then
   for env_file in .profile.d/*; do
     source $env_file
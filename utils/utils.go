@@ -5,13 +5,22 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 )
 
 const launcher = `
 cd "$1"
 
+if [ -n "$DEPS_DIR" ] && [ -d "$DEPS_DIR" ]; then
+  for env_file in "$DEPS_DIR"/*/profile.d/*; do
+    [ -f "$env_file" ] && source "$env_file"
+  done
+fi
+
 if [ -d .profile.d ]; then
   for env_file in .profile.d/*; do
     source $env_file
@@ -47,6 +56,12 @@ func SubDirs(dir string) ([]string, error) {
 		return dirs, err
 	}
 	for _, file := range contents {
+		if file.Mode()&os.ModeSymlink != 0 {
+			//a symlink counts as the directory it points to
+			if file, err = os.Stat(dir + "/" + file.Name()); err != nil {
+				continue
+			}
+		}
 		if file.IsDir() {
 			dirs = append(dirs, file.Name())
 		}
@@ -77,6 +92,39 @@ func AddLauncherRunScript(appDir string) error {
 	return ioutil.WriteFile(appDir+"/cloudrocker-start-1c4352a23e52040ddb1857d7675fe3cc.sh", []byte(launcher), 0644)
 }
 
+func PortAvailable(port int) bool {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+func FreePort() (int, error) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// DirSize totals the size of the regular files below dir
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 func Pwd() string {
 	pwd, err := os.Getwd()
 	if err != nil {
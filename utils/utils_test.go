@@ -2,6 +2,7 @@ package utils_test
 
 import (
 	"io/ioutil"
+	"net"
 	"os"
 
 	"github.com/cloudcredo/cloudrocker/utils"
@@ -56,6 +57,19 @@ var _ = Describe("Utils", func() {
 			Expect(dirs).ShouldNot(ContainElement("testfile"))
 			os.RemoveAll(parentDir)
 		})
+
+		It("should count symlinks to directories as subdirectories", func() {
+			parentDir, _ := ioutil.TempDir(os.TempDir(), "utils-test-subdirs")
+			os.Mkdir(parentDir+"/dir1", 0755)
+			ioutil.WriteFile(parentDir+"/testfile", []byte("test"), 0644)
+			os.Symlink(parentDir+"/dir1", parentDir+"/linkeddir")
+			os.Symlink(parentDir+"/testfile", parentDir+"/linkedfile")
+			os.Symlink(parentDir+"/missing", parentDir+"/brokenlink")
+			dirs, err := utils.SubDirs(parentDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(dirs).Should(ConsistOf("dir1", "linkeddir"))
+			os.RemoveAll(parentDir)
+		})
 	})
 
 	Describe("Copying the rocker binary to its own directory", func() {
@@ -84,6 +98,35 @@ var _ = Describe("Utils", func() {
 		})
 	})
 
+	Describe("Finding host ports", func() {
+		It("should return a port that is free to use", func() {
+			port, err := utils.FreePort()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(port).To(BeNumerically(">", 0))
+			Expect(utils.PortAvailable(port)).To(Equal(true))
+		})
+
+		It("should know when a port is already in use", func() {
+			listener, err := net.Listen("tcp", ":0")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer listener.Close()
+			Expect(utils.PortAvailable(listener.Addr().(*net.TCPAddr).Port)).To(Equal(false))
+		})
+	})
+
+	Describe("Sizing a directory", func() {
+		It("should total the sizes of the files in the directory and its subdirectories", func() {
+			parentDir, _ := ioutil.TempDir(os.TempDir(), "utils-test-dirsize")
+			os.Mkdir(parentDir+"/dir1", 0755)
+			ioutil.WriteFile(parentDir+"/testfile", []byte("test"), 0644)
+			ioutil.WriteFile(parentDir+"/dir1/testfile", []byte("another test"), 0644)
+			size, err := utils.DirSize(parentDir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(size).To(Equal(int64(16)))
+			os.RemoveAll(parentDir)
+		})
+	})
+
 	Describe("Getting the user's PWD", func() {
 		It("should return the PWD", func() {
 			testDir, _ := ioutil.TempDir(os.TempDir(), "utils-test-pwd")